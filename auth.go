@@ -0,0 +1,177 @@
+package minichain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+	Requests are authenticated with tokens listed in [Auth] section of
+	config. Token is sent in Authorization header as "Bearer <token>" or in
+	X-Api-Key header. Every token is scoped to key prefixes and to read and
+	write permissions, so several teams can share a node.
+
+		[Auth]
+		IsOn = true
+
+		[[Auth.Token]]
+		Name = "orders"
+		Token = "secret"
+		Prefixes = ["order-"]
+		Read = true
+		Write = true
+
+	Empty prefix gives access to all keys. Searches by range of keys or by
+	time without key need a prefix that covers the whole range.
+*/
+
+const (
+	PERMISSION_READ  = "read"
+	PERMISSION_WRITE = "write"
+
+	API_KEY_HEADER = "X-Api-Key"
+)
+
+var (
+	UnauthorizedErr = errors.New("authentication token is missing or unknown")
+	ForbiddenErr    = errors.New("token is not allowed to access key")
+)
+
+type Token struct {
+	Name     string
+	Prefixes []string
+	Read     bool
+	Write    bool
+}
+
+type Authenticator struct {
+	// Tokens by sha256 of token, so lookup time doesn't depend on token
+	// contents
+	tokens map[[sha256.Size]byte]*Token
+}
+
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	auth := &Authenticator{
+		tokens: make(map[[sha256.Size]byte]*Token),
+	}
+
+	for _, tokenConfig := range config.Token {
+		if len(tokenConfig.Token) == 0 {
+			return nil, fmt.Errorf("token %s is empty", tokenConfig.Name)
+		}
+
+		hash := sha256.Sum256([]byte(tokenConfig.Token))
+
+		if _, ok := auth.tokens[hash]; ok {
+			return nil, fmt.Errorf("token %s is duplicated", tokenConfig.Name)
+		}
+
+		auth.tokens[hash] = &Token{
+			Name:     tokenConfig.Name,
+			Prefixes: tokenConfig.Prefixes,
+			Read:     tokenConfig.Read,
+			Write:    tokenConfig.Write,
+		}
+	}
+
+	GetLogger().Infof("Authentication is on with %d tokens", len(auth.tokens))
+	return auth, nil
+}
+
+// Returns token of request, UnauthorizedErr if token is missing or unknown
+func (auth *Authenticator) Authenticate(r *http.Request) (*Token, error) {
+	token := r.Header.Get(API_KEY_HEADER)
+
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	if len(token) == 0 {
+		return nil, UnauthorizedErr
+	}
+
+	if t, ok := auth.tokens[sha256.Sum256([]byte(token))]; ok {
+		return t, nil
+	}
+
+	return nil, UnauthorizedErr
+}
+
+// Checks that token has permission, nil token is used when authentication
+// is off and allows everything.
+func (token *Token) Allow(permission string) error {
+	if token == nil {
+		return nil
+	}
+
+	if permission == PERMISSION_READ && token.Read || permission == PERMISSION_WRITE && token.Write {
+		return nil
+	}
+
+	return ForbiddenErr
+}
+
+// Checks that token has permission for key
+func (token *Token) AllowKey(permission, key string) error {
+	if token == nil {
+		return nil
+	}
+
+	if err := token.Allow(permission); err != nil {
+		return err
+	}
+
+	for _, prefix := range token.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return nil
+		}
+	}
+
+	return ForbiddenErr
+}
+
+// Checks that token has permission for all keys of range [start, end),
+// empty end means that range is not bounded.
+func (token *Token) AllowRange(permission, start, end string) error {
+	if token == nil {
+		return nil
+	}
+
+	if err := token.Allow(permission); err != nil {
+		return err
+	}
+
+	for _, prefix := range token.Prefixes {
+		if !strings.HasPrefix(start, prefix) {
+			continue
+		}
+
+		// Keys of prefix are below prefixEnd, prefix of 0xff bytes is not bounded
+		if prefixEnd := prefixEnd(prefix); len(prefixEnd) == 0 || len(end) != 0 && end <= prefixEnd {
+			return nil
+		}
+	}
+
+	return ForbiddenErr
+}
+
+// Checks that token can read keys of found transaction or of all
+// transactions of found block
+func (result *LookupResult) allow(token *Token) error {
+	if result.Transaction != nil {
+		return token.AllowKey(PERMISSION_READ, result.Transaction.Key)
+	}
+
+	if result.Block != nil {
+		for _, tx := range result.Block.Transactions {
+			if err := token.AllowKey(PERMISSION_READ, tx.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return token.Allow(PERMISSION_READ)
+}
@@ -0,0 +1,97 @@
+package minichain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testAuthenticator(t *testing.T) *Authenticator {
+	auth, err := NewAuthenticator(AuthConfig{
+		IsOn: true,
+		Token: []TokenConfig{
+			{Name: "orders", Token: "orders-token", Prefixes: []string{"order-"}, Read: true, Write: true},
+			{Name: "reader", Token: "reader-token", Prefixes: []string{""}, Read: true},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return auth
+}
+
+func TestAuthenticate(t *testing.T) {
+	auth := testAuthenticator(t)
+
+	testData := []struct {
+		Header       string
+		Value        string
+		ExpectedName string
+		ExpectedErr  error
+	}{
+		{"Authorization", "Bearer orders-token", "orders", nil},
+		{API_KEY_HEADER, "reader-token", "reader", nil},
+		{"Authorization", "Bearer unknown", "", UnauthorizedErr},
+		{"Authorization", "orders-token", "", UnauthorizedErr},
+		{"", "", "", UnauthorizedErr},
+	}
+
+	for _, test := range testData {
+		req := httptest.NewRequest(http.MethodGet, "/tx", nil)
+
+		if len(test.Header) != 0 {
+			req.Header.Set(test.Header, test.Value)
+		}
+
+		token, err := auth.Authenticate(req)
+
+		if err != test.ExpectedErr {
+			t.Errorf("%s: %s expected error %v actual %v", test.Header, test.Value, test.ExpectedErr, err)
+			continue
+		}
+
+		if err == nil && token.Name != test.ExpectedName {
+			t.Errorf("Expected token %s actual %s", test.ExpectedName, token.Name)
+		}
+	}
+
+	if _, err := NewAuthenticator(AuthConfig{Token: []TokenConfig{{Name: "empty"}}}); err == nil {
+		t.Errorf("Authenticator with empty token has been created")
+	}
+}
+
+func TestTokenAllow(t *testing.T) {
+	orders := &Token{Prefixes: []string{"order-"}, Read: true, Write: true}
+	reader := &Token{Prefixes: []string{""}, Read: true}
+	var off *Token
+
+	testData := []struct {
+		Token       *Token
+		Permission  string
+		Start       string
+		End         string
+		ExpectedErr error
+	}{
+		{orders, PERMISSION_WRITE, "order-1", "order-2", nil},
+		{orders, PERMISSION_READ, "order-", prefixEnd("order-"), nil},
+		{orders, PERMISSION_READ, "order-a", "order-b", nil},
+		{orders, PERMISSION_READ, "order-a", "user", ForbiddenErr},
+		{orders, PERMISSION_READ, "order-a", "", ForbiddenErr},
+		{orders, PERMISSION_READ, "", "", ForbiddenErr},
+		{reader, PERMISSION_READ, "", "", nil},
+		{reader, PERMISSION_WRITE, "a", "b", ForbiddenErr},
+		{off, PERMISSION_WRITE, "", "", nil},
+	}
+
+	for i, test := range testData {
+		if err := test.Token.AllowRange(test.Permission, test.Start, test.End); err != test.ExpectedErr {
+			t.Errorf("test %d expected error %v actual %v", i, test.ExpectedErr, err)
+		}
+	}
+
+	if err := orders.AllowKey(PERMISSION_READ, "user-1"); err != ForbiddenErr {
+		t.Errorf("Expected error %v actual %v", ForbiddenErr, err)
+	}
+}
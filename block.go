@@ -3,39 +3,176 @@ package minichain
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"strconv"
 	"time"
 )
 
+const (
+	// Blocks written before versioning, hash covers only transaction ids
+	// and timestamp
+	BLOCK_VERSION_LEGACY = 0
+	// Hash covers block header: version, height, prev block hash, merkle
+	// root of transactions and timestamp
+	BLOCK_VERSION_HEADER = 1
+	// Transaction values are bytes encoded with base64, previous versions
+	// store them as JSON strings
+	BLOCK_VERSION_BINARY = 2
+	// Every transaction has operation, in previous versions transactions
+	// without operation are puts
+	BLOCK_VERSION_TYPED = 3
+
+	BLOCK_VERSION = BLOCK_VERSION_TYPED
+)
+
+// Block version is schema version of data file record, record written by
+// newer release is reported instead of being misread.
+var UnsupportedVersionErr = errors.New("block version is not supported")
+
 type Block struct {
+	Version       uint32 `json:"version,omitempty"`
+	Height        uint64 `json:"height,omitempty"`
 	Timestamp     int64
 	PrevBlockHash []byte        `json:"prev-block-hash"`
+	MerkleRoot    []byte        `json:"merkle-root,omitempty"`
 	BlockHash     []byte        `json:"block-hash"`
 	Transactions  []Transaction `json:"transactions"`
 }
 
-func NewBlock(prevBlockHash []byte, transactions []Transaction) *Block {
-	var txHashes [][]byte
-	var txHash [32]byte
-
+func NewBlock(height uint64, prevBlockHash []byte, transactions []Transaction) *Block {
 	block := &Block{
+		Version:       BLOCK_VERSION,
+		Height:        height,
 		Timestamp:     time.Now().Unix(),
 		PrevBlockHash: prevBlockHash,
+		MerkleRoot:    merkleRoot(transactions),
 		Transactions:  transactions,
 	}
 
-	GetLogger().Debugf("Create new block with timestamp %d prev-block-hash %s tx count",
-		block.Timestamp, string(block.PrevBlockHash), len(block.Transactions))
+	GetLogger().Debugf("Create new block height %d with timestamp %d prev-block-hash %x tx count %d",
+		block.Height, block.Timestamp, block.PrevBlockHash, len(block.Transactions))
+
+	block.BlockHash = block.Hash()
+
+	return block
+}
+
+// Hash computes hash of the block from its contents, it does not rely on
+// BlockHash field, so it can be used to validate blocks read from disk.
+func (block *Block) Hash() []byte {
+	var hash [32]byte
+
+	if block.Version == BLOCK_VERSION_LEGACY {
+		hash = sha256.Sum256(block.legacyHeader())
+	} else {
+		hash = sha256.Sum256(block.Header())
+	}
+
+	return hash[:]
+}
+
+// Header returns canonical encoding of block header, each variable size
+// field is prefixed with its length.
+func (block *Block) Header() []byte {
+	buf := &bytes.Buffer{}
+
+	binary.Write(buf, binary.LittleEndian, block.Version)
+	binary.Write(buf, binary.LittleEndian, block.Height)
+	writeBytes(buf, block.PrevBlockHash)
+	writeBytes(buf, block.MerkleRoot)
+	binary.Write(buf, binary.LittleEndian, block.Timestamp)
+
+	return buf.Bytes()
+}
+
+func (block *Block) legacyHeader() []byte {
+	var txHashes [][]byte
 
-	for _, tx := range transactions {
+	for _, tx := range block.Transactions {
 		txHashes = append(txHashes, tx.Id)
 	}
 
 	timestampBytes := []byte(strconv.FormatInt(block.Timestamp, 10))
 	txHashes = append(txHashes, timestampBytes)
 
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
-	block.BlockHash = txHash[:]
+	return bytes.Join(txHashes, []byte{})
+}
 
-	return block
+// Returns transaction with id or nil if block doesn't contain it
+func (block *Block) Transaction(id []byte) *Transaction {
+	for i := range block.Transactions {
+		if bytes.Equal(block.Transactions[i].Id, id) {
+			return &block.Transactions[i]
+		}
+	}
+
+	return nil
+}
+
+// Returns whether block contains transaction of key
+func (block *Block) hasKey(key string) bool {
+	for i := range block.Transactions {
+		if block.Transactions[i].Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Transaction as it is stored in blocks written before BLOCK_VERSION_BINARY
+type stringTransaction struct {
+	Id        []byte `json:"id"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Decodes block taking into account format of transactions of block version
+func (block *Block) UnmarshalJSON(data []byte) error {
+	// Alias type has no methods, so it doesn't call UnmarshalJSON recursively
+	type blockAlias Block
+
+	version := &struct {
+		Version uint32 `json:"version"`
+	}{}
+
+	if err := json.Unmarshal(data, version); err != nil {
+		return err
+	}
+
+	if version.Version > BLOCK_VERSION {
+		return UnsupportedVersionErr
+	}
+
+	if version.Version >= BLOCK_VERSION_BINARY {
+		return json.Unmarshal(data, (*blockAlias)(block))
+	}
+
+	// Transactions field of outer struct shadows the one of embedded block
+	legacy := &struct {
+		*blockAlias
+		Transactions []stringTransaction `json:"transactions"`
+	}{
+		blockAlias: (*blockAlias)(block),
+	}
+
+	if err := json.Unmarshal(data, legacy); err != nil {
+		return err
+	}
+
+	block.Transactions = make([]Transaction, 0, len(legacy.Transactions))
+
+	for _, tx := range legacy.Transactions {
+		block.Transactions = append(block.Transactions, Transaction{
+			Id:        tx.Id,
+			Key:       tx.Key,
+			Value:     []byte(tx.Value),
+			Timestamp: tx.Timestamp,
+		})
+	}
+
+	return nil
 }
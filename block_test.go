@@ -0,0 +1,79 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+)
+
+func TestBlockHashCoversPrevBlockHash(t *testing.T) {
+	hash1 := sha256.Sum256([]byte("hash1"))
+	hash2 := sha256.Sum256([]byte("hash2"))
+	transactions := []Transaction{*NewTransaction("key", []byte("value"))}
+
+	block1 := NewBlock(1, hash1[:], transactions)
+	block2 := *block1
+	block2.PrevBlockHash = hash2[:]
+
+	if bytes.Equal(block1.Hash(), block2.Hash()) {
+		t.Errorf("Blocks with different prev block hash have the same hash")
+	}
+}
+
+func TestBlockUnmarshalStringValues(t *testing.T) {
+	data := []byte(`{"Timestamp": 1, "transactions": [{"key": "key", "value": "d29ybGQ="}]}`)
+	block := &Block{}
+
+	if err := json.Unmarshal(data, block); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(block.Transactions[0].Value) != "d29ybGQ=" {
+		t.Errorf("Value of legacy block is decoded as base64 %v", block.Transactions[0].Value)
+	}
+
+	data = []byte(`{"version": 2, "Timestamp": 1, "transactions": [{"key": "key", "value": "d29ybGQ="}]}`)
+
+	if err := json.Unmarshal(data, block); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(block.Transactions[0].Value) != "world" {
+		t.Errorf("Expected value %s actual %s", "world", block.Transactions[0].Value)
+	}
+}
+
+func TestBlockUnmarshalVersion(t *testing.T) {
+	data := []byte(`{"version": 2, "Timestamp": 1, "transactions": [{"key": "key", "value": "d29ybGQ="}]}`)
+	block := &Block{}
+
+	if err := json.Unmarshal(data, block); err != nil {
+		t.Fatal(err)
+	}
+
+	if op := block.Transactions[0].Type(); op != OP_PUT {
+		t.Errorf("Expected operation %s actual %s", OP_PUT, op)
+	}
+
+	data = []byte(`{"version": 99, "Timestamp": 1, "transactions": []}`)
+
+	if err := json.Unmarshal(data, block); err != UnsupportedVersionErr {
+		t.Errorf("Expected error %v actual %v", UnsupportedVersionErr, err)
+	}
+}
+
+func TestBlockHasKey(t *testing.T) {
+	block := NewBlock(0, nil, []Transaction{
+		*NewTransaction("hello", []byte("world")),
+		*NewTransaction("apple", []byte("pie")),
+	})
+
+	if !block.hasKey("apple") {
+		t.Errorf("Block does not contain key %s", "apple")
+	}
+
+	if block.hasKey("hell") {
+		t.Errorf("Block contains key %s", "hell")
+	}
+}
@@ -2,9 +2,9 @@ package minichain
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
+	"io"
 	"os"
 	"time"
 )
@@ -13,6 +13,11 @@ const (
 	HEADER_SIZE   = 4
 	DIGEST_SIZE   = 32
 	GENESIS_BLOCK = "Genesis block"
+	// Count of commit requests that wait for blockchain loop when mempool
+	// size is not set
+	DEFAULT_MEMPOOL_SIZE = 1024
+	// Seconds between snapshots of index when interval is not set
+	DEFAULT_SNAPSHOT_INTERVAL = 60
 )
 
 type BlockChain struct {
@@ -21,28 +26,92 @@ type BlockChain struct {
 	reader *os.File
 	ticker *time.Ticker
 
-	indexOn       bool
-	dataFileName  string
-	offset        int64
-	index         Index
-	blockSize     int
-	lastBlockHash []byte
-	timeout       time.Duration
+	indexOn      bool
+	indexType    string
+	snapshotFile string
+	// Data file offset covered by the last saved snapshot
+	snapshotOffset   int64
+	snapshotInterval time.Duration
+	dataFileName     string
+	offset           int64
+	height           uint64
+	index            Index
+	hashIndex        *HashIndex
+	timeIndex        *TimeIndex
+	stateIndex       *StateIndex
+	blockSize        int
+	lastBlockHash    []byte
+	timeout          time.Duration
+	// Time of the last flush or of start
+	lastFlush time.Time
+	// Requests with compare-and-set transactions come back here after state
+	// of their keys has been read, count of requests being read
+	checked  chan *casCheck
+	checking int
+	// Snapshot is written off the loop, offset it covers or -1 if it has
+	// not been written comes back here
+	snapshotSaved chan int64
+	saving        bool
+	// Transactions that have been accepted but not flushed yet
+	pending pendingSet
 
 	Input    chan *Transaction
 	ShutDown chan chan struct{}
 	Search   chan *SearchRequest
+	Proof    chan *ProofRequest
+	Lookup   chan *LookupRequest
+	Status   chan *StatusRequest
+	Commit   chan *CommitRequest
+	State    chan *StateRequest
+	Info     chan *ChainStatusRequest
 }
 
 func NewBlockChain(config *Config) (*BlockChain, error) {
-	f, err := os.OpenFile(config.BlockChain.DataFile, os.O_RDONLY, 0600)
-	f.Close()
+	var (
+		hashIndex         *HashIndex
+		timeIndex         *TimeIndex
+		stateIndex        *StateIndex
+		from              SnapshotHeader
+		update            func(int64, uint64, *Block)
+		chainSnapshotFile string
+		snapshotInterval  time.Duration
+	)
+
+	if config.Index.IsOn {
+		if len(config.Index.SnapshotFile) != 0 {
+			chainSnapshotFile = config.Index.SnapshotFile + CHAIN_SNAPSHOT_SUFFIX
+			snapshotInterval = time.Duration(config.Index.SnapshotInterval) * time.Second
+
+			if snapshotInterval <= 0 {
+				snapshotInterval = DEFAULT_SNAPSHOT_INTERVAL * time.Second
+			}
+		}
+
+		hashIndex, timeIndex, stateIndex, from = loadChainSnapshot(chainSnapshotFile, config.Index.StateIndex)
+		update = func(offset int64, height uint64, block *Block) {
+			hashIndex.Update(offset, height, block)
+			timeIndex.Update(offset, height, block)
+			stateIndex.Update(offset, height, block)
+		}
+	}
 
-	prevBlockHash, err := getLastBlockHash(f)
+	// Drop torn record that could be left by crash during flush, hash, time
+	// and state indexes are built with the same pass over blocks after snapshot
+	start := time.Now()
+	prevBlockHash, height, offset, err := recoverChain(config.BlockChain.DataFile, from, update)
+
+	if err == SnapshotStaleErr {
+		GetLogger().Warnf("Skip chain snapshot %s: %v", chainSnapshotFile, err)
+		hashIndex, timeIndex, stateIndex, from = loadChainSnapshot("", config.Index.StateIndex)
+		prevBlockHash, height, offset, err = recoverChain(config.BlockChain.DataFile, from, update)
+	}
 
 	if err != nil {
-		hash := sha256.Sum256([]byte(GENESIS_BLOCK))
-		prevBlockHash = hash[:]
+		return nil, err
+	}
+
+	if config.Index.IsOn {
+		indexBuildSeconds.WithLabelValues(CHAIN_INDEXES).Set(time.Since(start).Seconds())
 	}
 
 	// TODO(stgleb): Consider usage of O_DIRECT mode for writing
@@ -58,33 +127,58 @@ func NewBlockChain(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
-	var (
-		index  Index
-		offset int64
-	)
+	var index Index
 
 	if config.Index.IsOn {
-		index, offset, err = NewIndex(reader, config.Index.IndexType)
+		start := time.Now()
+		index, _, err = NewIndex(reader, config.Index)
 
 		if err != nil {
 			return nil, err
 		}
+
+		indexBuildSeconds.WithLabelValues(config.Index.IndexType).Set(time.Since(start).Seconds())
 	}
 
+	mempoolSize := config.BlockChain.MempoolSize
+
+	if mempoolSize <= 0 {
+		mempoolSize = DEFAULT_MEMPOOL_SIZE
+	}
+
+	chainHeight.Set(float64(height))
 	m := &BlockChain{
-		reader:        reader,
-		writer:        file,
-		ticker:        time.NewTicker(time.Second * time.Duration(config.BlockChain.TimeOut)),
-		dataFileName:  config.BlockChain.DataFile,
-		offset:        offset,
-		index:         index,
-		indexOn:       config.Index.IsOn,
-		lastBlockHash: prevBlockHash,
-		blockSize:     config.BlockChain.BlockSize,
-		timeout:       time.Duration(config.BlockChain.TimeOut) * time.Second,
-		Input:         make(chan *Transaction),
-		ShutDown:      make(chan chan struct{}),
-		Search:        make(chan *SearchRequest),
+		reader:       reader,
+		writer:       file,
+		ticker:       time.NewTicker(time.Second * time.Duration(config.BlockChain.TimeOut)),
+		dataFileName: config.BlockChain.DataFile,
+		offset:       offset,
+		height:       height,
+		index:        index,
+		hashIndex:    hashIndex,
+		timeIndex:    timeIndex,
+		stateIndex:   stateIndex,
+		indexOn:      config.Index.IsOn,
+		indexType:    config.Index.IndexType,
+		snapshotFile: config.Index.SnapshotFile,
+		// Snapshot is saved at least once even if data file has not changed
+		snapshotOffset:   -1,
+		snapshotInterval: snapshotInterval,
+		lastBlockHash:    prevBlockHash,
+		blockSize:        config.BlockChain.BlockSize,
+		timeout:          time.Duration(config.BlockChain.TimeOut) * time.Second,
+		Input:            make(chan *Transaction),
+		ShutDown:         make(chan chan struct{}),
+		Search:           make(chan *SearchRequest),
+		Proof:            make(chan *ProofRequest),
+		Lookup:           make(chan *LookupRequest),
+		Status:           make(chan *StatusRequest),
+		Commit:           make(chan *CommitRequest, mempoolSize),
+		State:            make(chan *StateRequest),
+		Info:             make(chan *ChainStatusRequest),
+		lastFlush:        time.Now(),
+		checked:          make(chan *casCheck),
+		snapshotSaved:    make(chan int64, 1),
 	}
 
 	go m.Run()
@@ -93,14 +187,41 @@ func NewBlockChain(config *Config) (*BlockChain, error) {
 }
 
 func (b *BlockChain) Run() {
-	var transactions = make([]Transaction, 0, b.blockSize)
+	var (
+		transactions = make([]Transaction, 0, b.blockSize)
+		// Channels of clients that wait for current batch to be synced
+		waiters = make([]chan error, 0)
+		// Snapshots are not saved when interval is not set
+		snapshots <-chan time.Time
+	)
+
+	if b.snapshotInterval > 0 {
+		ticker := time.NewTicker(b.snapshotInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
 
 	for {
 		select {
 		case ch := <-b.ShutDown:
 			GetLogger().Info("Shutdown blockchain")
-			if err := b.flush(transactions); err != nil {
-				GetLogger().Error(err)
+			transactions, waiters = b.drain(transactions, waiters)
+			b.commit(FLUSH_BY_SHUTDOWN, transactions, waiters)
+
+			// Snapshot being written is waited for, the last one is written
+			// right away
+			if b.saving {
+				b.snapshotWritten(<-b.snapshotSaved)
+			}
+
+			if b.needsSnapshot() {
+				b.snapshotWritten(b.snapshot()())
+			}
+
+			if closer, ok := b.index.(io.Closer); ok {
+				if err := closer.Close(); err != nil {
+					GetLogger().Errorf("Error closing index %s", err.Error())
+				}
 			}
 
 			if err := b.reader.Close(); err != nil {
@@ -115,62 +236,360 @@ func (b *BlockChain) Run() {
 			return
 		case tx := <-b.Input:
 			GetLogger().Infof("Receive transaction %v", tx)
-			transactions = append(transactions, *tx)
-
-			if len(transactions) == b.blockSize {
-				if err := b.flush(transactions); err != nil {
-					GetLogger().Error(err)
-				}
-				// Reset ticket after transaction pool overflow
-				b.ticker = time.NewTicker(b.timeout)
-				transactions = make([]Transaction, 0, b.blockSize)
-			}
+			b.pending.add([]Transaction{*tx}, nil)
+			transactions, waiters = b.receive(transactions, waiters, &CommitRequest{
+				Transactions: []Transaction{*tx},
+			})
+		case commitRequest := <-b.Commit:
+			GetLogger().Infof("Receive %d transactions", len(commitRequest.Transactions))
+			transactions, waiters = b.receive(transactions, waiters, commitRequest)
+		case check := <-b.checked:
+			transactions, waiters = b.enqueueChecked(transactions, waiters, check)
 		case <-b.ticker.C:
 			GetLogger().Info("flush by ticker")
-			if err := b.flush(transactions); err != nil {
-				GetLogger().Error(err)
-			}
+			b.commit(FLUSH_BY_TICKER, transactions, waiters)
 
 			transactions = make([]Transaction, 0, b.blockSize)
+			waiters = make([]chan error, 0)
+		case <-snapshots:
+			b.saveIndex()
+		case offset := <-b.snapshotSaved:
+			b.snapshotWritten(offset)
 		case searchRequest := <-b.Search:
-			GetLogger().Infof("Search by key %s", searchRequest.Key)
+			GetLogger().Infof("Search by key %s range [%s, %s)",
+				searchRequest.Key, searchRequest.Start, searchRequest.End)
+
+			go func() {
+				searchResult := b.search(searchRequest)
+
+				select {
+				case <-searchRequest.ctx.Done():
+					return
+				case searchRequest.ResultChan <- searchResult:
+				}
+			}()
+		case proofRequest := <-b.Proof:
+			GetLogger().Infof("Build proof for tx %x", proofRequest.TxId)
+
+			go func() {
+				proof, err := b.proof(proofRequest.TxId)
+				proofResult := &ProofResult{
+					Proof: proof,
+					Err:   err,
+				}
+
+				if err != nil {
+					GetLogger().Error(err)
+					proofResult.Error = err.Error()
+				}
+
+				select {
+				case <-proofRequest.ctx.Done():
+					return
+				case proofRequest.ResultChan <- proofResult:
+				}
+			}()
+		case lookupRequest := <-b.Lookup:
+			GetLogger().Infof("Lookup by hash %x", lookupRequest.Hash)
 
 			go func() {
-				var (
-					err          error
-					transactions []Transaction
-				)
-
-				// Search for key with in-memory inverted index and full scan of blockchain
-				if b.indexOn {
-					transactions, err = b.index.Get(searchRequest.Key)
+				lookupResult := &LookupResult{}
+				block, location, err := b.lookup(lookupRequest.Hash, lookupRequest.ByTx)
+
+				if err != nil {
+					GetLogger().Error(err)
+					lookupResult.Err = err
+					lookupResult.Error = err.Error()
+				} else if lookupRequest.ByTx {
+					lookupResult.Transaction = block.Transaction(lookupRequest.Hash)
+					lookupResult.BlockHash = block.BlockHash
 				} else {
-					transactions, err = fullScan(searchRequest.Key, b.reader)
-					// Set file pointer to begin of the file after scan
-					b.reader.Seek(0, 0)
+					lookupResult.Block = block
+				}
+
+				lookupResult.BlockLocation = location
+
+				select {
+				case <-lookupRequest.ctx.Done():
+					return
+				case lookupRequest.ResultChan <- lookupResult:
 				}
+			}()
+		case stateRequest := <-b.State:
+			GetLogger().Infof("State of key %s", stateRequest.Key)
 
-				var errStr string
+			go func() {
+				stateResult := &StateResult{}
+				versions, next, err := b.state(stateRequest)
 
 				if err != nil {
 					GetLogger().Error(err)
-					errStr = err.Error()
+					stateResult.Err = err
+					stateResult.Error = err.Error()
 				}
-				searchResult := &SearchResult{
-					transactions,
-					errStr,
+
+				if stateRequest.History {
+					stateResult.Versions = versions
+					stateResult.Next = next
+				} else if len(versions) != 0 {
+					// Tombstone is returned with KeyDeletedErr
+					stateResult.Version = &versions[0]
 				}
 
 				select {
-				case <-searchRequest.ctx.Done():
+				case <-stateRequest.ctx.Done():
 					return
-				case searchRequest.ResultChan <- searchResult:
+				case stateRequest.ResultChan <- stateResult:
 				}
 			}()
+		case statusRequest := <-b.Status:
+			GetLogger().Infof("Status of tx %x", statusRequest.TxId)
+			go b.status(statusRequest)
+		case infoRequest := <-b.Info:
+			// Answered right away, result channel is buffered
+			infoRequest.ResultChan <- b.chainStatus(len(transactions))
 		}
 	}
 }
 
+// Adds transactions that wait in mempool to the batch, so transactions
+// accepted before shutdown are not lost.
+func (b *BlockChain) drain(transactions []Transaction, waiters []chan error) ([]Transaction, []chan error) {
+	for {
+		select {
+		case tx := <-b.Input:
+			b.pending.add([]Transaction{*tx}, nil)
+			transactions, waiters = b.receive(transactions, waiters, &CommitRequest{
+				Transactions: []Transaction{*tx},
+			})
+		case commitRequest := <-b.Commit:
+			transactions, waiters = b.receive(transactions, waiters, commitRequest)
+		case check := <-b.checked:
+			transactions, waiters = b.enqueueChecked(transactions, waiters, check)
+		default:
+			// Requests which keys are being read are waited for
+			if b.checking == 0 {
+				return transactions, waiters
+			}
+
+			transactions, waiters = b.enqueueChecked(transactions, waiters, <-b.checked)
+		}
+	}
+}
+
+// Returns count of commit requests waiting in mempool, request is counted
+// once whatever count of transactions it has
+func (b *BlockChain) MempoolDepth() int {
+	return len(b.Commit)
+}
+
+// Puts commit request to mempool without waiting, transactions of request
+// are pending from now on until they are flushed or rejected. Request with
+// signed transaction that has been accepted before is rejected.
+func (b *BlockChain) Queue(commitRequest *CommitRequest) error {
+	if err := b.pending.add(commitRequest.Transactions, b.isCommitted); err != nil {
+		return err
+	}
+
+	select {
+	case b.Commit <- commitRequest:
+		return nil
+	default:
+		b.pending.remove(commitRequest.Transactions)
+		return MempoolFullErr
+	}
+}
+
+// Enqueues request, request with compare-and-set transaction is enqueued
+// after committed state of its keys is read off the loop.
+func (b *BlockChain) receive(transactions []Transaction, waiters []chan error,
+	commitRequest *CommitRequest) ([]Transaction, []chan error) {
+	if !hasCompareAndSet(commitRequest.Transactions) {
+		return b.enqueue(transactions, waiters, commitRequest, nil)
+	}
+
+	b.checking++
+	go b.readStates(commitRequest, b.offset, b.height)
+
+	return transactions, waiters
+}
+
+// Enqueues request which keys state has been read, blocks flushed after the
+// read are applied to the state first.
+func (b *BlockChain) enqueueChecked(transactions []Transaction, waiters []chan error,
+	check *casCheck) ([]Transaction, []chan error) {
+	b.checking--
+
+	if check.err == nil {
+		check.err = b.updateStates(check)
+	}
+
+	if check.err != nil {
+		GetLogger().Errorf("Error reading state of keys %s", check.err.Error())
+		b.pending.remove(check.request.Transactions)
+
+		if check.request.ResultChan != nil {
+			check.request.ResultChan <- check.err
+		}
+
+		return transactions, waiters
+	}
+
+	return b.enqueue(transactions, waiters, check.request, check.states)
+}
+
+// Appends transactions of request to the batch and flushes it when block
+// size is reached. Request that fits into block is never split between
+// blocks: current batch is flushed first if there is no room for it.
+// Request with compare-and-set transaction that does not match is rejected
+// as a whole. The rest of request split between blocks is dropped when
+// flush of its part fails.
+func (b *BlockChain) enqueue(transactions []Transaction, waiters []chan error,
+	commitRequest *CommitRequest, states map[string]keyState) ([]Transaction, []chan error) {
+	resultChan := commitRequest.ResultChan
+	last := len(commitRequest.Transactions) - 1
+
+	if err := b.check(transactions, commitRequest.Transactions, states); err != nil {
+		GetLogger().Infof("Reject %d transactions: %v", len(commitRequest.Transactions), err)
+		rejectedTransactions.WithLabelValues(REJECT_CONFLICT).Add(float64(len(commitRequest.Transactions)))
+		b.pending.remove(commitRequest.Transactions)
+
+		if resultChan != nil {
+			resultChan <- err
+		}
+
+		return transactions, waiters
+	}
+
+	if len(transactions)+len(commitRequest.Transactions) > b.blockSize &&
+		len(commitRequest.Transactions) <= b.blockSize {
+		b.commit(FLUSH_BY_SIZE, transactions, waiters)
+		transactions = make([]Transaction, 0, b.blockSize)
+		waiters = make([]chan error, 0)
+	}
+
+	acceptedTransactions.Add(float64(len(commitRequest.Transactions)))
+
+	for i, tx := range commitRequest.Transactions {
+		transactions = append(transactions, tx)
+
+		// Client waits for the block with the last transaction of request
+		if i == last && resultChan != nil {
+			waiters = append(waiters, resultChan)
+		}
+
+		if len(transactions) == b.blockSize {
+			err := b.commit(FLUSH_BY_SIZE, transactions, waiters)
+			// Reset ticket after transaction pool overflow
+			b.ticker = time.NewTicker(b.timeout)
+			transactions = make([]Transaction, 0, b.blockSize)
+			waiters = make([]chan error, 0)
+
+			// Client that gets error must not find part of request written later
+			if err != nil && i < last {
+				GetLogger().Errorf("Drop %d transactions of request after failed flush", last-i)
+				b.pending.remove(commitRequest.Transactions[i+1:])
+
+				if resultChan != nil {
+					resultChan <- err
+				}
+
+				return transactions, waiters
+			}
+		}
+	}
+
+	return transactions, waiters
+}
+
+// Copies index state and writes snapshots in separate goroutine, so
+// commits and searches are not blocked while snapshot is encoded. Nothing
+// is saved if previous snapshot is still being written.
+func (b *BlockChain) saveIndex() {
+	if !b.needsSnapshot() || b.saving {
+		return
+	}
+
+	write := b.snapshot()
+	b.saving = true
+
+	go func() {
+		b.snapshotSaved <- write()
+	}()
+}
+
+// Snapshot is saved if index supports it and some blocks have been flushed
+// since the last snapshot
+func (b *BlockChain) needsSnapshot() bool {
+	return b.indexOn && len(b.snapshotFile) != 0 && b.snapshotOffset != b.offset
+}
+
+// Copies snapshot of index if index supports it and snapshot of hash, time
+// and state indexes, returns function that writes them and returns offset
+// they cover or -1 on error.
+func (b *BlockChain) snapshot() func() int64 {
+	var indexSnapshot interface{}
+
+	if persistentIndex, ok := b.index.(PersistentIndex); ok {
+		indexSnapshot = persistentIndex.Snapshot(b.offset, b.lastBlockHash)
+	}
+
+	header := SnapshotHeader{
+		Offset:        b.offset,
+		LastBlockHash: b.lastBlockHash,
+		Height:        b.height,
+	}
+
+	snapshotFile := b.snapshotFile
+	chainSnapshot := newChainSnapshot(header, b.hashIndex, b.timeIndex, b.stateIndex)
+
+	return func() int64 {
+		if indexSnapshot != nil {
+			if err := writeSnapshot(snapshotFile, indexSnapshot); err != nil {
+				GetLogger().Errorf("Error saving index snapshot %s", err.Error())
+				return -1
+			}
+		}
+
+		if err := writeSnapshot(snapshotFile+CHAIN_SNAPSHOT_SUFFIX, chainSnapshot); err != nil {
+			GetLogger().Errorf("Error saving chain snapshot %s", err.Error())
+			return -1
+		}
+
+		GetLogger().Infof("Index snapshots have been saved to %s", snapshotFile)
+		return header.Offset
+	}
+}
+
+func (b *BlockChain) snapshotWritten(offset int64) {
+	b.saving = false
+
+	if offset >= 0 {
+		b.snapshotOffset = offset
+	}
+}
+
+// Flushes batch and sends result to waiters, trigger tells what made batch
+// flush for metrics. Transactions are not pending after that, flushed ones
+// are found by hash index.
+func (b *BlockChain) commit(trigger string, transactions []Transaction, waiters []chan error) error {
+	err := b.flush(transactions)
+	b.pending.remove(transactions)
+
+	if err != nil {
+		GetLogger().Error(err)
+	} else if len(transactions) != 0 {
+		blocksFlushed.WithLabelValues(trigger).Inc()
+	}
+
+	// Result channels are buffered, so slow client doesn't block the loop
+	for _, waiter := range waiters {
+		waiter <- err
+	}
+
+	return err
+}
+
 func (b *BlockChain) flush(transactions []Transaction) error {
 	var block *Block
 
@@ -180,7 +599,12 @@ func (b *BlockChain) flush(transactions []Transaction) error {
 		return nil
 	}
 
-	block = NewBlock(b.lastBlockHash, transactions)
+	start := time.Now()
+	defer func() {
+		flushSeconds.Observe(time.Since(start).Seconds())
+	}()
+
+	block = NewBlock(b.height, b.lastBlockHash, transactions)
 	blockBytes, err := json.Marshal(block)
 
 	if err != nil {
@@ -200,18 +624,391 @@ func (b *BlockChain) flush(transactions []Transaction) error {
 	}
 
 	GetLogger().Debugf("Bytes written %d", n)
+	syncStart := time.Now()
 	err = b.writer.Sync()
+	fsyncSeconds.Observe(time.Since(syncStart).Seconds())
 
 	if err != nil {
 		return err
 	}
 
-	// Update index with block that was written to disk
+	blockSizeBytes.Observe(float64(len(data)))
+
+	// Update index with block that was written to disk. Readers go from key
+	// index to hash index and from head of key to key index, so hash index
+	// is updated first and state index is the last.
 	if b.indexOn {
+		b.hashIndex.Update(b.offset, b.height, block)
+		b.timeIndex.Update(b.offset, b.height, block)
 		b.index.Update(b.offset, block)
+		b.stateIndex.Update(b.offset, b.height, block)
 	}
 	b.offset += int64(len(data))
+	b.height++
 	b.lastBlockHash = block.BlockHash
+	b.lastFlush = time.Now()
+	chainHeight.Set(float64(b.height))
 
 	return nil
 }
+
+// Range search with index or full scan of blockchain
+func (b *BlockChain) scan(start, end string, cursor Cursor, limit int) ([]Transaction, string, *Cursor, error) {
+	if b.indexOn {
+		return b.index.Scan(start, end, cursor, limit)
+	}
+
+	f, err := os.Open(b.dataFileName)
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer f.Close()
+
+	return rangeScan(start, end, cursor, limit, f)
+}
+
+func (b *BlockChain) search(searchRequest *SearchRequest) *SearchResult {
+	var (
+		err          error
+		next         string
+		cursor       *Cursor
+		transactions []Transaction
+		indexType    = b.indexType
+		start        = time.Now()
+	)
+
+	if !b.indexOn {
+		indexType = NO_INDEX
+	} else if searchRequest.ByTime {
+		indexType = SEARCH_TIME_INDEX
+	}
+
+	defer func() {
+		searchSeconds.WithLabelValues(indexType).Observe(time.Since(start).Seconds())
+	}()
+
+	// Search for key with in-memory inverted index and full scan of blockchain
+	if searchRequest.ByTime {
+		transactions, cursor, err = b.timeScan(searchRequest.From, searchRequest.To, searchRequest.Key,
+			searchRequest.Start, searchRequest.End, searchRequest.Cursor, searchRequest.Limit)
+	} else if len(searchRequest.Key) == 0 {
+		transactions, next, cursor, err = b.scan(searchRequest.Start, searchRequest.End,
+			searchRequest.Cursor, searchRequest.Limit)
+	} else if b.indexOn {
+		transactions, cursor, err = b.index.Get(searchRequest.Key, searchRequest.Cursor, searchRequest.Limit)
+	} else {
+		transactions, cursor, err = b.fullScan(searchRequest.Key, searchRequest.Cursor, searchRequest.Limit)
+	}
+
+	var errStr string
+
+	if err != nil {
+		GetLogger().Error(err)
+		errStr = err.Error()
+	}
+
+	return &SearchResult{
+		Transactions: transactions,
+		Next:         next,
+		Cursor:       cursor,
+		Error:        errStr,
+	}
+}
+
+// Search by key without index, data file is opened separately for each scan
+func (b *BlockChain) fullScan(key string, cursor Cursor, limit int) ([]Transaction, *Cursor, error) {
+	f, err := os.Open(b.dataFileName)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return fullScan(key, cursor, limit, f)
+}
+
+// Search by time range, time index points to the block to start from
+func (b *BlockChain) timeScan(from, to int64, key, start, end string, cursor Cursor,
+	limit int) ([]Transaction, *Cursor, error) {
+	f, err := os.Open(b.dataFileName)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	offset := cursor.Offset
+
+	if b.indexOn {
+		if located := b.timeIndex.Locate(from - TIME_SCAN_MARGIN); located > offset {
+			offset = located
+		}
+	}
+
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, nil, err
+	}
+
+	return timeScan(from, to, key, start, end, cursor, limit, f)
+}
+
+// Checks hash index for transaction or scans data file when index is off
+func (b *BlockChain) isCommitted(txId []byte) (bool, error) {
+	if b.indexOn {
+		_, ok := b.hashIndex.Transaction(txId)
+		return ok, nil
+	}
+
+	_, _, err := b.lookup(txId, true)
+
+	// Data file is created by the first flush
+	if err == TxNotFoundErr || os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Builds inclusion proof for transaction
+func (b *BlockChain) proof(txId []byte) (*InclusionProof, error) {
+	block, _, err := b.lookup(txId, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewInclusionProof(block, txId)
+}
+
+// Finds block by its hash or by id of transaction that block contains.
+// Data file is opened separately to not interfere with index reads.
+func (b *BlockChain) lookup(hash []byte, byTx bool) (*Block, BlockLocation, error) {
+	notFoundErr := BlockNotFoundErr
+
+	if byTx {
+		notFoundErr = TxNotFoundErr
+	}
+
+	f, err := os.Open(b.dataFileName)
+
+	if err != nil {
+		return nil, BlockLocation{}, err
+	}
+	defer f.Close()
+
+	if !b.indexOn {
+		block, location, err := findBlock(f, func(block *Block) bool {
+			if !byTx {
+				return bytes.Equal(block.BlockHash, hash)
+			}
+
+			return block.Transaction(hash) != nil
+		})
+
+		if err == BlockNotFoundErr {
+			err = notFoundErr
+		}
+
+		return block, location, err
+	}
+
+	var (
+		location BlockLocation
+		ok       bool
+	)
+
+	if byTx {
+		location, ok = b.hashIndex.Transaction(hash)
+	} else {
+		location, ok = b.hashIndex.Block(hash)
+	}
+
+	if !ok {
+		return nil, location, notFoundErr
+	}
+
+	if _, err = f.Seek(location.Offset, 0); err != nil {
+		return nil, location, err
+	}
+
+	block, _, err := readBlock(f)
+
+	return block, location, err
+}
+
+// Reads versions of key selected by request and version to start the next
+// page of history from. Versions are taken from state index, collected from
+// blocks that key index points to or by scan of data file when index is
+// off. Key is absent after tombstone, its value is returned with
+// KeyDeletedErr, history keeps tombstones.
+func (b *BlockChain) state(stateRequest *StateRequest) ([]KeyVersion, int, error) {
+	f, err := os.Open(b.dataFileName)
+
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var (
+		versions []StateLocation
+		// Count of versions before the first one read
+		skip int
+	)
+
+	switch {
+	case !b.indexOn:
+		versions, err = keyVersions(stateRequest.Key, f)
+	case b.stateIndex.history:
+		versions = b.stateIndex.Versions(stateRequest.Key)
+	default:
+		versions, skip, err = b.indexVersions(stateRequest, f)
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(versions) == 0 {
+		return nil, 0, KeyNotFoundErr
+	}
+
+	// Latest version by default
+	first, last, next := len(versions)-1, len(versions), 0
+
+	if stateRequest.History {
+		first, last = stateRequest.Cursor, stateRequest.Cursor+stateRequest.Limit
+
+		if first > len(versions) {
+			first = len(versions)
+		}
+
+		if last < len(versions) {
+			next = last
+		} else {
+			last = len(versions)
+		}
+	} else if stateRequest.At != nil {
+		if first = versionAt(versions, *stateRequest.At); first < 0 {
+			return nil, 0, KeyNotFoundErr
+		}
+
+		last = first + 1
+	}
+
+	var (
+		block  *Block
+		value  []byte
+		result = make([]KeyVersion, 0, last-first)
+	)
+
+	// Appends are applied to the value starting from the version it is based on
+	start := first
+
+	if first < last {
+		start = versions[first].Base - skip
+	}
+
+	for i := start; i < last; i++ {
+		location := versions[i]
+
+		// Consequent versions are often in the same block
+		if block == nil || location.Offset != versions[i-1].Offset {
+			if _, err := f.Seek(location.Offset, 0); err != nil {
+				return nil, 0, err
+			}
+
+			if block, _, err = readBlock(f); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if location.Position >= len(block.Transactions) {
+			return nil, 0, RecordMismatchErr
+		}
+
+		tx := block.Transactions[location.Position]
+		value = applyTransaction(value, &tx)
+
+		if i < first {
+			continue
+		}
+
+		result = append(result, KeyVersion{
+			Version:       skip + i,
+			Transaction:   tx,
+			Value:         value,
+			BlockHash:     block.BlockHash,
+			BlockLocation: location.BlockLocation,
+		})
+	}
+
+	if !stateRequest.History && result[0].IsDelete() {
+		return result, 0, KeyDeletedErr
+	}
+
+	return result, next, nil
+}
+
+// Collects versions of key through key index. When request needs the
+// latest version only blocks starting from the one of its base are read,
+// count of versions before them is returned as well.
+func (b *BlockChain) indexVersions(stateRequest *StateRequest, f io.ReadSeeker) ([]StateLocation, int, error) {
+	head, ok := b.stateIndex.Head(stateRequest.Key)
+
+	if ok && !stateRequest.History && (stateRequest.At == nil || head.isLatestAt(*stateRequest.At)) {
+		return headVersions(stateRequest.Key, head, b.index, b.hashIndex, f)
+	}
+
+	versions, err := indexVersions(stateRequest.Key, b.index, b.hashIndex, f)
+
+	return versions, 0, err
+}
+
+// Transaction is removed from pending ones after it is added to hash index,
+// so it is checked first.
+func (b *BlockChain) status(statusRequest *StatusRequest) {
+	statusResult := &StatusResult{
+		Status: TX_PENDING,
+	}
+
+	if !b.pending.contains(statusRequest.TxId) {
+		block, location, err := b.lookup(statusRequest.TxId, true)
+
+		switch err {
+		case nil:
+			statusResult.Status = TX_COMMITTED
+			statusResult.BlockHash = block.BlockHash
+			statusResult.BlockLocation = location
+		case TxNotFoundErr:
+			statusResult.Status = TX_UNKNOWN
+		default:
+			GetLogger().Error(err)
+			statusResult.Status = TX_UNKNOWN
+			statusResult.Error = err.Error()
+		}
+	}
+
+	select {
+	case <-statusRequest.ctx.Done():
+	case statusRequest.ResultChan <- statusResult:
+	}
+}
+
+func (b *BlockChain) chainStatus(pending int) *ChainStatus {
+	indexType := b.indexType
+
+	if !b.indexOn {
+		indexType = NO_INDEX
+	}
+
+	return &ChainStatus{
+		Height:              b.height,
+		LastBlockHash:       b.lastBlockHash,
+		DataFileSize:        b.offset,
+		IndexType:           indexType,
+		PendingTransactions: pending,
+		MempoolDepth:        b.MempoolDepth(),
+		SinceLastFlush:      time.Since(b.lastFlush).Seconds(),
+	}
+}
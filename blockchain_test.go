@@ -0,0 +1,506 @@
+package minichain
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBlockChain(t *testing.T, blockSize int, indexOn bool) (*BlockChain, func()) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		BlockChain: BlockChainConfig{
+			BlockSize:    blockSize,
+			TimeOut:      60,
+			KeyMaxSize:   16,
+			ValueMaxSize: 16,
+			DataFile:     filepath.Join(dir, "blockchain.dat"),
+		},
+		Index: IndexConfig{
+			IndexType: INVERTED_INDEX,
+			IsOn:      indexOn,
+		},
+	}
+
+	blockChain, err := NewBlockChain(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return blockChain, func() {
+		doneChan := make(chan struct{})
+		blockChain.ShutDown <- doneChan
+		<-doneChan
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBlockChainLookup(t *testing.T) {
+	for _, indexOn := range []bool{true, false} {
+		blockChain, cleanup := newTestBlockChain(t, 1, indexOn)
+
+		tx := NewTransaction("key", []byte("value"))
+		if err := blockChain.flush([]Transaction{*tx}); err != nil {
+			t.Fatal(err)
+		}
+
+		tx2 := NewTransaction("key2", []byte("value2"))
+		if err := blockChain.flush([]Transaction{*tx2}); err != nil {
+			t.Fatal(err)
+		}
+
+		block, location, err := blockChain.lookup(tx2.Id, true)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if location.Height != 1 || location.Offset == 0 {
+			t.Errorf("Wrong location of tx %v", location)
+		}
+
+		block, location, err = blockChain.lookup(block.BlockHash, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if block.Transaction(tx2.Id) == nil {
+			t.Errorf("Block does not contain transaction %x", tx2.Id)
+		}
+
+		if _, _, err = blockChain.lookup([]byte("unknown"), true); err != TxNotFoundErr {
+			t.Errorf("Expected error %v actual %v", TxNotFoundErr, err)
+		}
+
+		if _, _, err = blockChain.lookup([]byte("unknown"), false); err != BlockNotFoundErr {
+			t.Errorf("Expected error %v actual %v", BlockNotFoundErr, err)
+		}
+
+		cleanup()
+	}
+}
+
+func TestBlockChainStatus(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 2, true)
+	defer cleanup()
+
+	status := func(txId []byte) *StatusResult {
+		resultChan := make(chan *StatusResult)
+		blockChain.Status <- &StatusRequest{
+			context.Background(),
+			txId,
+			resultChan,
+		}
+
+		return <-resultChan
+	}
+
+	tx := NewTransaction("key", []byte("value"))
+	blockChain.Input <- tx
+
+	if result := status(tx.Id); result.Status != TX_PENDING {
+		t.Errorf("Expected status %s actual %s", TX_PENDING, result.Status)
+	}
+
+	blockChain.Input <- NewTransaction("key2", []byte("value2"))
+
+	if result := status(tx.Id); result.Status != TX_COMMITTED || len(result.BlockHash) == 0 {
+		t.Errorf("Expected status %s actual %v", TX_COMMITTED, result)
+	}
+
+	if result := status([]byte("unknown")); result.Status != TX_UNKNOWN {
+		t.Errorf("Expected status %s actual %s", TX_UNKNOWN, result.Status)
+	}
+}
+
+func TestBlockChainQueue(t *testing.T) {
+	// Nobody reads mempool, like when blockchain loop is busy with flush
+	blockChain := &BlockChain{
+		Commit: make(chan *CommitRequest, 1),
+	}
+
+	status := func(txId []byte) string {
+		resultChan := make(chan *StatusResult, 1)
+		blockChain.status(&StatusRequest{context.Background(), txId, resultChan})
+
+		return (<-resultChan).Status
+	}
+
+	queued := NewTransaction("key", []byte("value"))
+
+	if err := blockChain.Queue(&CommitRequest{Transactions: []Transaction{*queued}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := status(queued.Id); actual != TX_PENDING {
+		t.Errorf("Expected status %s of transaction in mempool actual %s", TX_PENDING, actual)
+	}
+
+	rejected := NewTransaction("key2", []byte("value"))
+
+	if err := blockChain.Queue(&CommitRequest{Transactions: []Transaction{*rejected}}); err != MempoolFullErr {
+		t.Errorf("Expected error %v actual %v", MempoolFullErr, err)
+	}
+
+	if blockChain.pending.contains(rejected.Id) {
+		t.Errorf("Transaction rejected by full mempool is pending")
+	}
+}
+
+func TestBlockChainQueueDuplicate(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 1, true)
+	defer cleanup()
+
+	signed := NewTransaction("key", []byte("value"))
+	signed.Sign(testKey(1))
+
+	queue := func() error {
+		return blockChain.Queue(&CommitRequest{
+			Transactions: []Transaction{*signed},
+			ResultChan:   make(chan error, 1),
+		})
+	}
+
+	if err := blockChain.Queue(&CommitRequest{Transactions: []Transaction{*signed, *signed}}); err != DuplicateTransactionErr {
+		t.Errorf("Expected error %v for duplicate in request actual %v", DuplicateTransactionErr, err)
+	}
+
+	// Pending transaction is rejected even if mempool has room for replay
+	blockChain.pending.add([]Transaction{*signed}, nil)
+
+	if err := queue(); err != DuplicateTransactionErr {
+		t.Errorf("Expected error %v for pending transaction actual %v", DuplicateTransactionErr, err)
+	}
+
+	blockChain.pending.remove([]Transaction{*signed})
+	req := &CommitRequest{
+		Transactions: []Transaction{*signed},
+		ResultChan:   make(chan error, 1),
+	}
+
+	if err := blockChain.Queue(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-req.ResultChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := queue(); err != DuplicateTransactionErr {
+		t.Errorf("Expected error %v for committed transaction actual %v", DuplicateTransactionErr, err)
+	}
+
+	// Unsigned transactions are not checked
+	unsigned := NewTransaction("key", []byte("value"))
+
+	for i := 0; i < 2; i++ {
+		if err := blockChain.Queue(&CommitRequest{Transactions: []Transaction{*unsigned}}); err != nil {
+			t.Errorf("Unsigned transaction %d: %v", i, err)
+		}
+	}
+}
+
+func TestBlockChainCommit(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 1, true)
+	defer cleanup()
+
+	tx := NewTransaction("key", []byte("value"))
+	req := &CommitRequest{
+		[]Transaction{*tx},
+		make(chan error, 1),
+	}
+	blockChain.Commit <- req
+
+	if err := <-req.ResultChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := blockChain.lookup(tx.Id, true); err != nil {
+		t.Errorf("Committed transaction is not found %v", err)
+	}
+}
+
+func TestBlockChainBatchIsNotSplit(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 3, true)
+	defer cleanup()
+
+	tx1 := NewTransaction("key1", []byte("value1"))
+	blockChain.Input <- tx1
+	blockChain.Input <- NewTransaction("key2", []byte("value2"))
+
+	tx3 := NewTransaction("key3", []byte("value3"))
+	tx4 := NewTransaction("key4", []byte("value4"))
+	blockChain.Commit <- &CommitRequest{
+		Transactions: []Transaction{*tx3, *tx4},
+	}
+
+	req := &CommitRequest{
+		[]Transaction{*NewTransaction("key5", []byte("value5"))},
+		make(chan error, 1),
+	}
+	blockChain.Commit <- req
+
+	if err := <-req.ResultChan; err != nil {
+		t.Fatal(err)
+	}
+
+	_, location1, err := blockChain.lookup(tx1.Id, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, location3, err := blockChain.lookup(tx3.Id, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, location4, err := blockChain.lookup(tx4.Id, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if location1.Height != 0 || location3.Height != 1 || location4.Height != 1 {
+		t.Errorf("Batch has been split between blocks %v %v %v", location1, location3, location4)
+	}
+}
+
+func TestBlockChainSplitRequestFlushFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Writes to closed file fail
+	writer, err := os.Create(filepath.Join(dir, "blockchain.dat"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	blockChain := &BlockChain{
+		writer:    writer,
+		blockSize: 2,
+		timeout:   time.Minute,
+	}
+
+	req := &CommitRequest{
+		Transactions: []Transaction{
+			*NewTransaction("key1", []byte("value1")),
+			*NewTransaction("key2", []byte("value2")),
+			*NewTransaction("key3", []byte("value3")),
+		},
+		ResultChan: make(chan error, 1),
+	}
+
+	transactions, waiters := blockChain.enqueue(nil, nil, req, nil)
+
+	if err := <-req.ResultChan; err == nil {
+		t.Errorf("Error of failed flush is not reported")
+	}
+
+	if len(transactions) != 0 || len(waiters) != 0 {
+		t.Errorf("Rest of failed request is left in batch %v", transactions)
+	}
+}
+
+func TestBlockChainState(t *testing.T) {
+	testData := []struct {
+		IndexOn    bool
+		StateIndex bool
+	}{
+		{IndexOn: true, StateIndex: true},
+		{IndexOn: true, StateIndex: false},
+		{IndexOn: false, StateIndex: false},
+	}
+
+	for _, test := range testData {
+		blockChain, cleanup := newTestBlockChain(t, 2, test.IndexOn)
+
+		// Chain is empty, state index is built by flushes
+		if test.StateIndex {
+			blockChain.stateIndex = newStateIndex(true)
+		}
+
+		blocks := [][]Transaction{
+			{*NewTransaction("key", []byte("value0")), *NewTransaction("other", []byte("value"))},
+			{*NewTransaction("key", []byte("value1"))},
+			{*NewTransaction("key", []byte("value2")), *NewTransaction("key", []byte("value3"))},
+		}
+
+		for _, transactions := range blocks {
+			if err := blockChain.flush(transactions); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		versions, _, err := blockChain.state(&StateRequest{Key: "key"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if versions[0].Version != 3 || string(versions[0].Value) != "value3" || versions[0].Height != 2 {
+			t.Errorf("Wrong latest version %v", versions[0])
+		}
+
+		versions, _, err = blockChain.state(&StateRequest{Key: "key", At: &StatePoint{Height: 1}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if versions[0].Version != 1 || string(versions[0].Value) != "value1" {
+			t.Errorf("Wrong version at height %d %v", 1, versions[0])
+		}
+
+		versions, next, err := blockChain.state(&StateRequest{Key: "key", History: true, Limit: 3})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(versions) != 3 || next != 3 || string(versions[2].Value) != "value2" {
+			t.Errorf("Wrong first page of history %v next %d", versions, next)
+		}
+
+		versions, next, err = blockChain.state(&StateRequest{Key: "key", History: true, Cursor: next, Limit: 3})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(versions) != 1 || next != 0 || versions[0].Version != 3 {
+			t.Errorf("Wrong second page of history %v next %d", versions, next)
+		}
+
+		if _, _, err = blockChain.state(&StateRequest{Key: "unknown"}); err != KeyNotFoundErr {
+			t.Errorf("Expected error %v actual %v", KeyNotFoundErr, err)
+		}
+
+		cleanup()
+	}
+}
+
+func TestBlockChainStateDelete(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 1, true)
+	defer cleanup()
+
+	for _, tx := range []*Transaction{
+		NewTransaction("key", []byte("value")),
+		NewDeleteTransaction("key"),
+	} {
+		if err := blockChain.flush([]Transaction{*tx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, _, err := blockChain.state(&StateRequest{Key: "key"})
+
+	if err != KeyDeletedErr {
+		t.Errorf("Expected error %v actual %v", KeyDeletedErr, err)
+	}
+
+	if len(versions) != 1 || !versions[0].IsDelete() {
+		t.Errorf("Expected tombstone actual %v", versions)
+	}
+
+	versions, _, err = blockChain.state(&StateRequest{Key: "key", At: &StatePoint{Height: 0}})
+
+	if err != nil || string(versions[0].Value) != "value" {
+		t.Errorf("Wrong value before delete %v %v", versions, err)
+	}
+
+	versions, _, err = blockChain.state(&StateRequest{Key: "key", History: true, Limit: DEFAULT_SEARCH_LIMIT})
+
+	if err != nil || len(versions) != 2 || !versions[1].IsDelete() {
+		t.Errorf("History does not contain tombstone %v %v", versions, err)
+	}
+}
+
+func TestBlockChainStateAppend(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 2, true)
+	defer cleanup()
+
+	blocks := [][]Transaction{
+		{*NewTypedTransaction(OP_APPEND, "key", []byte("a"))},
+		{*NewTypedTransaction(OP_APPEND, "key", []byte("b")), *NewTransaction("key", []byte("c"))},
+		{*NewTypedTransaction(OP_APPEND, "key", []byte("d")), *NewTypedTransaction(OP_APPEND, "key", []byte("e"))},
+	}
+
+	var head keyHead
+
+	for i, transactions := range blocks {
+		if err := blockChain.flush(transactions); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 1 {
+			head, _ = blockChain.stateIndex.Head("key")
+		}
+	}
+
+	versions, _, err := blockChain.state(&StateRequest{Key: "key"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if versions[0].Version != 4 || string(versions[0].Value) != "cde" || string(versions[0].Transaction.Value) != "e" {
+		t.Errorf("Wrong latest version %d value %s of append %s",
+			versions[0].Version, versions[0].Value, versions[0].Transaction.Value)
+	}
+
+	// Blocks flushed after head has been read are not taken
+	f, err := os.Open(blockChain.dataFileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	locations, skip, err := headVersions("key", head, blockChain.index, blockChain.hashIndex, f)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if skip != 1 || len(locations) != 2 || locations[1].Base != 2 {
+		t.Errorf("Wrong versions of head %v skip %d", locations, skip)
+	}
+
+	versions, _, err = blockChain.state(&StateRequest{Key: "key", At: &StatePoint{Height: 0}})
+
+	if err != nil || string(versions[0].Value) != "a" {
+		t.Errorf("Wrong value at height %d %v %v", 0, versions, err)
+	}
+
+	versions, _, err = blockChain.state(&StateRequest{Key: "key", History: true, Cursor: 1, Limit: 3})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"ab", "c", "cd"}
+
+	for i, version := range versions {
+		if string(version.Value) != expected[i] {
+			t.Errorf("Expected value %s of version %d actual %s", expected[i], version.Version, version.Value)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package minichain
 
 import (
+	"bytes"
+	"encoding/binary"
 	"github.com/willf/bloom"
 	"io"
-	"math"
+	"io/ioutil"
+	"os"
 	"sync"
 )
 
@@ -12,6 +15,8 @@ import (
 	saves disk read operations.
 */
 
+const DEFAULT_FALSE_POSITIVE_RATE = 0.0001
+
 type BlockInfo struct {
 	filter *bloom.BloomFilter
 	offset int64
@@ -22,109 +27,312 @@ type BloomFilterIndex struct {
 	m      sync.RWMutex
 	blocks []*BlockInfo
 	file   io.ReadSeeker
+	// Filters of new blocks are appended to filter file when it is set
+	filters           io.WriteCloser
+	falsePositiveRate float64
 }
 
-func NewBloomFilterIndex(file io.ReadSeeker) (Index, int64, error) {
+func NewBloomFilterIndex(file io.ReadSeeker, falsePositiveRate float64) (Index, int64, error) {
 	GetLogger().Info("Start building index")
 
 	index := &BloomFilterIndex{
-		file:   file,
-		blocks: make([]*BlockInfo, 0, 32),
+		file:              file,
+		blocks:            make([]*BlockInfo, 0, 32),
+		falsePositiveRate: falsePositiveRate,
+	}
+
+	return index.replay(file)
+}
+
+// Loads filters from filter file and builds filters only for blocks that
+// are not in that file. Filter file is cut at the first record that is torn
+// or does not match the block in data file.
+func LoadBloomFilterIndex(file io.ReadSeeker, filterFile string, falsePositiveRate float64) (Index, int64, error) {
+	GetLogger().Infof("Start loading index from %s", filterFile)
+
+	data, err := ioutil.ReadFile(filterFile)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+
+	index := &BloomFilterIndex{
+		file:              file,
+		blocks:            make([]*BlockInfo, 0, 32),
+		falsePositiveRate: falsePositiveRate,
 	}
 
 	var (
-		err        error
-		blockCount int64
-		offset     int64
-		block      *Block
+		reader = bytes.NewReader(data)
+		// Offset of the first block that has no filter
+		offset int64
+		// Size of valid part of filter file
+		size int64
 	)
 
-	for {
-		if block, offset, err = readBlock(file); err != nil {
-			if err != io.EOF {
-				return nil, 0, err
-			} else {
-				break
-			}
-		} else {
-			GetLogger().Debugf("Read block id %s on offset %d",
-				string(block.BlockHash), offset)
-			// Such parameter of hash function count minimizes false positive rate minimizes
-			filter := bloom.New(uint(len(block.Transactions)), uint(math.Ceil(0.69*20)))
-			info := &BlockInfo{
-				filter: filter,
-				offset: offset,
-			}
-
-			for _, tx := range block.Transactions {
-				info.filter.AddString(tx.Key)
-			}
-			// Append info about the block
-			index.blocks = append(index.blocks, info)
-			blockCount++
+	for reader.Len() > 0 {
+		blockOffset, hash, filter, err := ReadFilterRecord(reader)
+
+		if err == nil && blockOffset != offset {
+			err = RecordMismatchErr
+		}
+
+		var next int64
+
+		if err == nil {
+			next, err = checkRecord(file, blockOffset, hash)
 		}
+
+		if err != nil {
+			GetLogger().Warnf("Broken filter record on offset %d in %s: %v, rebuild filters from block on offset %d",
+				size, filterFile, err, offset)
+			break
+		}
+
+		index.blocks = append(index.blocks, &BlockInfo{
+			filter: filter,
+			offset: blockOffset,
+		})
+		offset = next
+		size = int64(len(data) - reader.Len())
+	}
+
+	GetLogger().Debugf("%d filters have been loaded from %s", len(index.blocks), filterFile)
+
+	filters, err := os.OpenFile(filterFile, os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := filters.Truncate(size); err != nil {
+		filters.Close()
+		return nil, 0, err
+	}
+
+	if _, err := filters.Seek(size, 0); err != nil {
+		filters.Close()
+		return nil, 0, err
+	}
+
+	index.filters = filters
+
+	if _, err := file.Seek(offset, 0); err != nil {
+		index.Close()
+		return nil, 0, err
+	}
+
+	result, offset, err := index.replay(file)
+
+	if err != nil {
+		index.Close()
+	}
+
+	return result, offset, err
+}
+
+// Adds filters of blocks from current position of file till the end
+func (index *BloomFilterIndex) replay(file io.ReadSeeker) (Index, int64, error) {
+	blockCount, err := replay(file, func(offset int64, height uint64, block *Block) {
+		GetLogger().Debugf("Read block id %x on offset %d", block.BlockHash, offset)
+		index.Update(offset, block)
+	})
+
+	if err != nil {
+		return nil, 0, err
 	}
 
-	GetLogger().Debugf("BloomFilterIndex has been built from %d blocks", blockCount)
+	offset, err := file.Seek(0, 1)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	GetLogger().Debugf("BloomFilterIndex has been updated with %d blocks", blockCount)
 	return index, offset, nil
 }
 
-func (index *BloomFilterIndex) Get(key string) ([]Transaction, error) {
+// Filter record in filter file has following format, all numbers are
+// little endian.
+//
+//	offset(8) | block hash(32) | filter
+func WriteFilterRecord(w io.Writer, offset int64, hash []byte, filter *bloom.BloomFilter) error {
+	buf := &bytes.Buffer{}
+
+	if err := binary.Write(buf, binary.LittleEndian, offset); err != nil {
+		return err
+	}
+
+	buf.Write(hash)
+
+	if _, err := filter.WriteTo(buf); err != nil {
+		return err
+	}
+
+	// Record is written with one call so only the last record can be torn
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// Reads filter record written by WriteFilterRecord, filters can be read
+// this way without decoding blocks.
+func ReadFilterRecord(r io.Reader) (int64, []byte, *bloom.BloomFilter, error) {
+	var offset int64
+
+	if err := binary.Read(r, binary.LittleEndian, &offset); err != nil {
+		return 0, nil, nil, err
+	}
+
+	hash := make([]byte, DIGEST_SIZE)
+
+	if _, err := io.ReadFull(r, hash); err != nil {
+		return 0, nil, nil, err
+	}
+
+	filter := &bloom.BloomFilter{}
+
+	if _, err := filter.ReadFrom(r); err != nil {
+		return 0, nil, nil, err
+	}
+
+	return offset, hash, filter, nil
+}
+
+// Filter size depends only on count of transactions and false positive
+// rate, so block gets the same filter whenever it is indexed.
+func newFilter(block *Block, falsePositiveRate float64) *bloom.BloomFilter {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = DEFAULT_FALSE_POSITIVE_RATE
+	}
+
+	count := uint(len(block.Transactions))
+
+	if count == 0 {
+		count = 1
+	}
+
+	filter := bloom.NewWithEstimates(count, falsePositiveRate)
+
+	for _, tx := range block.Transactions {
+		filter.AddString(tx.Key)
+	}
+
+	return filter
+}
+
+func (index *BloomFilterIndex) Get(key string, cursor Cursor, limit int) ([]Transaction, *Cursor, error) {
 	// Protect Get method with Write lock since it modifies Seeker e.g fd state.
 	index.m.Lock()
 	defer index.m.Unlock()
 
 	var (
-		err          error
+		next         *Cursor
 		transactions = make([]Transaction, 0)
 	)
 
 	for _, blockInfo := range index.blocks {
-		// If block doesn't contain the key
-		if !blockInfo.filter.TestString(key) {
+		if next != nil {
+			break
+		}
+
+		// If block doesn't contain the key or is before cursor
+		if blockInfo.offset < cursor.Offset || !blockInfo.filter.TestString(key) {
 			continue
 		}
 
-		offset := blockInfo.offset
-		_, err = index.file.Seek(offset, 0)
+		if _, err := index.file.Seek(blockInfo.offset, 0); err != nil {
+			return nil, nil, err
+		}
+
+		block, _, err := readBlock(index.file)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		if !block.hasKey(key) {
+			bloomFalsePositives.Inc()
+		}
+
+		// Check whether block contains key or not
+		transactions, next = pageBlock(block, blockInfo.offset, cursor, limit, transactions, func(tx *Transaction) bool {
+			return tx.Key == key
+		})
+	}
+
+	return transactions, next, nil
+}
+
+// Bloom filter can't tell which keys are in range, so all blocks are read
+func (index *BloomFilterIndex) Scan(start, end string, cursor Cursor, limit int) ([]Transaction, string, *Cursor, error) {
+	// Protect Scan method with Write lock since it modifies Seeker e.g fd state.
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	page := newRangePage(start, end, cursor, limit)
+
+	for _, blockInfo := range index.blocks {
+		if _, err := index.file.Seek(blockInfo.offset, 0); err != nil {
+			return nil, "", nil, err
 		}
 
 		block, _, err := readBlock(index.file)
 
 		if err != nil {
-			return nil, err
+			return nil, "", nil, err
 		}
 
-		// Check whether block contains key or not
-		for _, tx := range block.Transactions {
-			if string(tx.Key) == key {
-				transactions = append(transactions, tx)
-			}
+		page.add(block, blockInfo.offset)
+	}
+
+	transactions, next, nextCursor := page.result()
+
+	return transactions, next, nextCursor, nil
+}
+
+func (index *BloomFilterIndex) Offsets(key string) ([]int64, error) {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	offsets := make([]int64, 0)
+
+	for _, blockInfo := range index.blocks {
+		if blockInfo.filter.TestString(key) {
+			offsets = append(offsets, blockInfo.offset)
 		}
 	}
 
-	return transactions, nil
+	return offsets, nil
 }
 
 // Update index with new transactions
 func (index *BloomFilterIndex) Update(offset int64, block *Block) {
-	// Such parameter of hash functions gives least false positive probability 0.0001
-	filter := bloom.New(uint(len(block.Transactions))*20, uint(math.Ceil(0.69*20)))
 	info := &BlockInfo{
-		filter: filter,
+		filter: newFilter(block, index.falsePositiveRate),
 		offset: offset,
 	}
 
-	for _, tx := range block.Transactions {
-		info.filter.AddString(tx.Key)
-	}
-
 	// Protect slice with lock for update
 	index.m.Lock()
+	defer index.m.Unlock()
+
 	index.blocks = append(index.blocks, info)
-	index.m.Unlock()
 
+	if index.filters == nil {
+		return
+	}
+
+	if err := WriteFilterRecord(index.filters, offset, block.BlockHash, info.filter); err != nil {
+		GetLogger().Errorf("Error writing filter of block %x %s", block.BlockHash, err.Error())
+	}
+}
+
+// Close closes filter file
+func (index *BloomFilterIndex) Close() error {
+	if index.filters == nil {
+		return nil
+	}
+
+	return index.filters.Close()
 }
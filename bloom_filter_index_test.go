@@ -6,7 +6,11 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"github.com/willf/bloom"
+	"io"
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -25,13 +29,13 @@ func TestBloomFilterIndexGet(t *testing.T) {
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       key,
-				Value:     "value1",
+				Value:     []byte("value1"),
 			},
 			{
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       "key2",
-				Value:     "value2",
+				Value:     []byte("value2"),
 			},
 		},
 	}
@@ -63,7 +67,7 @@ func TestBloomFilterIndexGet(t *testing.T) {
 		file:   file,
 	}
 
-	txs, err := index.Get(key)
+	txs, _, err := index.Get(key, Cursor{}, DEFAULT_SEARCH_LIMIT)
 
 	if err != nil {
 		t.Error(err)
@@ -88,13 +92,13 @@ func TestBloomFilterIndexUpdate(t *testing.T) {
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       key,
-				Value:     "value1",
+				Value:     []byte("value1"),
 			},
 			{
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       "key2",
-				Value:     "value2",
+				Value:     []byte("value2"),
 			},
 		},
 	}
@@ -113,3 +117,140 @@ func TestBloomFilterIndexUpdate(t *testing.T) {
 		t.Errorf("Cannot find key in index")
 	}
 }
+
+func TestBloomFilterSize(t *testing.T) {
+	blocks, data := buildChain(t, 1)
+	block := blocks[0]
+
+	built, _, err := NewBloomFilterIndex(bytes.NewReader(data), DEFAULT_FALSE_POSITIVE_RATE)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := &BloomFilterIndex{
+		falsePositiveRate: DEFAULT_FALSE_POSITIVE_RATE,
+	}
+	updated.Update(0, block)
+
+	builtFilter := built.(*BloomFilterIndex).blocks[0].filter
+	updatedFilter := updated.blocks[0].filter
+
+	if builtFilter.Cap() != updatedFilter.Cap() || builtFilter.K() != updatedFilter.K() {
+		t.Errorf("Filters of the same block differ: m %d k %d and m %d k %d",
+			builtFilter.Cap(), builtFilter.K(), updatedFilter.Cap(), updatedFilter.K())
+	}
+}
+
+func TestLoadBloomFilterIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 4)
+	lastSize := len(encodeRecord(t, blocks[3]))
+	filterFile := filepath.Join(dir, "blockchain.bloom")
+
+	// Filter file covers the first three blocks
+	index, _, err := LoadBloomFilterIndex(bytes.NewReader(data[:len(data)-lastSize]), filterFile, DEFAULT_FALSE_POSITIVE_RATE)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := index.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	filters, err := ioutil.ReadFile(filterFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bytes.NewReader(filters)
+	recordCount := 0
+
+	for reader.Len() > 0 {
+		offset, hash, filter, err := ReadFilterRecord(reader)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block := blocks[recordCount]
+
+		if !bytes.Equal(hash, block.BlockHash) || !filter.TestString(block.Transactions[0].Key) {
+			t.Errorf("Wrong filter record for block on offset %d", offset)
+		}
+
+		recordCount++
+	}
+
+	if recordCount != 3 {
+		t.Fatalf("Expected filter count %d actual %d", 3, recordCount)
+	}
+
+	testData := []struct {
+		Name    string
+		Filters []byte
+	}{
+		{
+			Name:    "append",
+			Filters: filters,
+		},
+		{
+			Name:    "torn",
+			Filters: filters[:len(filters)-1],
+		},
+		{
+			Name:    "empty",
+			Filters: []byte{},
+		},
+	}
+
+	for _, testCase := range testData {
+		if err := ioutil.WriteFile(filterFile, testCase.Filters, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, offset, err := LoadBloomFilterIndex(bytes.NewReader(data), filterFile, DEFAULT_FALSE_POSITIVE_RATE)
+
+		if err != nil {
+			t.Errorf("%s: %v", testCase.Name, err)
+			continue
+		}
+
+		loaded.(io.Closer).Close()
+
+		if offset != int64(len(data)) {
+			t.Errorf("%s: expected offset %d actual %d", testCase.Name, len(data), offset)
+		}
+
+		if count := len(loaded.(*BloomFilterIndex).blocks); count != len(blocks) {
+			t.Errorf("%s: expected filter count %d actual %d", testCase.Name, len(blocks), count)
+		}
+
+		for _, block := range blocks {
+			txs, _, err := loaded.Get(block.Transactions[0].Key, Cursor{}, DEFAULT_SEARCH_LIMIT)
+
+			if err != nil || len(txs) != 1 {
+				t.Errorf("%s: key %s not found %v", testCase.Name, block.Transactions[0].Key, err)
+			}
+		}
+
+		info, err := os.Stat(filterFile)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Broken records are replaced, so filter file has records of all blocks
+		if info.Size() <= int64(len(filters)) {
+			t.Errorf("%s: filter file has not been extended, size %d", testCase.Name, info.Size())
+		}
+	}
+}
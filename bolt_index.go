@@ -0,0 +1,423 @@
+package minichain
+
+import (
+	"encoding/binary"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+	Bolt index keeps the same data as inverted index in embedded key-value
+	store, so memory does not grow with count of keys.
+
+	Index
+
+		keys
+			apple  - {0}
+			banana - {28}
+			hello  - {0, 76}
+		meta
+			offset          - 76
+			last-block-hash - hash of block on offset 76
+
+	Each key has nested bucket with big endian offsets of blocks, cursor of
+	nested bucket returns them in chain order. Meta is updated in the same
+	transaction as keys, on start index is replayed from the block after the
+	last indexed one. Block that failed to be indexed is not skipped, the next
+	update replays all blocks after the last indexed one.
+*/
+
+const (
+	// Count of blocks added to index with one transaction on replay
+	BOLT_REPLAY_BATCH = 256
+	BOLT_OPEN_TIMEOUT = time.Second
+)
+
+var (
+	keysBucket       = []byte("keys")
+	metaBucket       = []byte("meta")
+	offsetMetaKey    = []byte("offset")
+	blockHashMetaKey = []byte("last-block-hash")
+)
+
+type BoltIndex struct {
+	// Mutex protects seeker from concurrent reads
+	m    sync.Mutex
+	db   *bolt.DB
+	file io.ReadSeeker
+	// Update of the last block failed, index is behind data file
+	behind bool
+}
+
+// Opens index database and adds blocks that are not indexed yet, index is
+// rebuilt from scratch when its last block does not match data file.
+func NewBoltIndex(file io.ReadSeeker, dbFile string) (Index, int64, error) {
+	GetLogger().Infof("Start loading index from %s", dbFile)
+
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: BOLT_OPEN_TIMEOUT})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	index := &BoltIndex{
+		db:   db,
+		file: file,
+	}
+
+	offset, err := index.lastOffset()
+
+	if err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+
+	if _, err := file.Seek(offset, 0); err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+
+	blockCount, err := index.replay(file)
+
+	if err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+
+	if offset, err = file.Seek(0, 1); err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+
+	GetLogger().Debugf("BoltIndex has been updated with %d blocks", blockCount)
+	return index, offset, nil
+}
+
+// Returns offset of the first block that is not indexed, drops index if it
+// does not match data file.
+func (index *BoltIndex) lastOffset() (int64, error) {
+	var (
+		offset    int64
+		blockHash []byte
+	)
+
+	err := index.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+
+		if meta == nil {
+			return nil
+		}
+
+		if value := meta.Get(offsetMetaKey); len(value) == 8 {
+			offset = int64(binary.BigEndian.Uint64(value))
+			blockHash = append([]byte{}, meta.Get(blockHashMetaKey)...)
+		}
+
+		return nil
+	})
+
+	if err != nil || blockHash == nil {
+		return 0, err
+	}
+
+	next, err := checkRecord(index.file, offset, blockHash)
+
+	if err == nil {
+		return next, nil
+	}
+
+	GetLogger().Warnf("Index does not match block on offset %d: %v, rebuild index", offset, err)
+
+	err = index.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{keysBucket, metaBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return 0, err
+}
+
+// Adds blocks from current position of file till the end, blocks are
+// written in batches to avoid sync of database on every block.
+func (index *BoltIndex) replay(file io.ReadSeeker) (uint64, error) {
+	var (
+		blockCount uint64
+		offsets    = make([]int64, 0, BOLT_REPLAY_BATCH)
+		blocks     = make([]*Block, 0, BOLT_REPLAY_BATCH)
+	)
+
+	flush := func() error {
+		err := index.db.Update(func(tx *bolt.Tx) error {
+			for i := range blocks {
+				if err := index.update(tx, offsets[i], blocks[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		blockCount += uint64(len(blocks))
+		offsets, blocks = offsets[:0], blocks[:0]
+
+		return err
+	}
+
+	for {
+		block, offset, err := readBlock(file)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return blockCount, err
+		}
+
+		offsets = append(offsets, offset)
+		blocks = append(blocks, block)
+
+		if len(blocks) == BOLT_REPLAY_BATCH {
+			if err := flush(); err != nil {
+				return blockCount, err
+			}
+		}
+	}
+
+	return blockCount, flush()
+}
+
+// Adds block keys and moves meta to the block
+func (index *BoltIndex) update(tx *bolt.Tx, offset int64, block *Block) error {
+	keys, err := tx.CreateBucketIfNotExists(keysBucket)
+
+	if err != nil {
+		return err
+	}
+
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+
+	if err != nil {
+		return err
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(offset))
+
+	for _, transaction := range block.Transactions {
+		offsets, err := keys.CreateBucketIfNotExists([]byte(transaction.Key))
+
+		if err != nil {
+			return err
+		}
+
+		// Key appears in the block several times, put is idempotent
+		if err := offsets.Put(value, []byte{}); err != nil {
+			return err
+		}
+	}
+
+	if err := meta.Put(offsetMetaKey, value); err != nil {
+		return err
+	}
+
+	return meta.Put(blockHashMetaKey, block.BlockHash)
+}
+
+func (index *BoltIndex) Get(key string, cursor Cursor, limit int) ([]Transaction, *Cursor, error) {
+	// Protect Get method with lock since it modifies Seeker e.g fd state.
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	var (
+		next         *Cursor
+		transactions = make([]Transaction, 0)
+	)
+
+	err := index.db.View(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+
+		if keys == nil || keys.Bucket([]byte(key)) == nil {
+			return KeyNotFoundErr
+		}
+
+		var err error
+		transactions, next, err = index.page(keys.Bucket([]byte(key)), key, cursor, limit, transactions)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return transactions, next, nil
+}
+
+// Appends transactions of key with offsets in bucket starting from cursor to
+// the page, returns cursor of the next transaction of key when page is full.
+func (index *BoltIndex) page(offsets *bolt.Bucket, key string, cursor Cursor, limit int,
+	transactions []Transaction) ([]Transaction, *Cursor, error) {
+	var next *Cursor
+	start := make([]byte, 8)
+	binary.BigEndian.PutUint64(start, uint64(cursor.Offset))
+	c := offsets.Cursor()
+
+	for value, _ := c.Seek(start); value != nil && next == nil; value, _ = c.Next() {
+		offset := int64(binary.BigEndian.Uint64(value))
+
+		if _, err := index.file.Seek(offset, 0); err != nil {
+			return nil, nil, err
+		}
+
+		block, _, err := readBlock(index.file)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transactions, next = pageBlock(block, offset, cursor, limit, transactions, func(tx *Transaction) bool {
+			return tx.Key == key
+		})
+	}
+
+	return transactions, next, nil
+}
+
+func (index *BoltIndex) Scan(start, end string, cursor Cursor, limit int) ([]Transaction, string, *Cursor, error) {
+	// Protect Scan method with lock since it modifies Seeker e.g fd state.
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	var (
+		next         string
+		nextCursor   *Cursor
+		transactions = make([]Transaction, 0)
+	)
+
+	err := index.db.View(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+
+		if keys == nil {
+			return nil
+		}
+
+		c := keys.Cursor()
+
+		for k, _ := c.Seek([]byte(start)); k != nil && nextCursor == nil; k, _ = c.Next() {
+			key := string(k)
+
+			if !keyInRange(key, start, end) {
+				break
+			}
+
+			// Cursor points into transactions of the first key only
+			var keyCursor Cursor
+
+			if key == start {
+				keyCursor = cursor
+			}
+
+			var err error
+			transactions, nextCursor, err = index.page(keys.Bucket(k), key, keyCursor, limit, transactions)
+
+			if err != nil {
+				return err
+			}
+
+			next = key
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if nextCursor == nil {
+		return transactions, "", nil, nil
+	}
+
+	return transactions, next, nextCursor, nil
+}
+
+func (index *BoltIndex) Offsets(key string) ([]int64, error) {
+	offsets := make([]int64, 0)
+
+	err := index.db.View(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(keysBucket)
+
+		if keys == nil || keys.Bucket([]byte(key)) == nil {
+			return nil
+		}
+
+		c := keys.Bucket([]byte(key)).Cursor()
+
+		for value, _ := c.First(); value != nil; value, _ = c.Next() {
+			offsets = append(offsets, int64(binary.BigEndian.Uint64(value)))
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return offsets, nil
+}
+
+// Update index with new transactions, blocks missed by failed updates are
+// added first.
+func (index *BoltIndex) Update(offset int64, block *Block) {
+	// Protect Update method with lock since replay modifies Seeker e.g fd state.
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	var err error
+
+	if index.behind {
+		err = index.catchUp()
+	} else {
+		err = index.db.Update(func(tx *bolt.Tx) error {
+			return index.update(tx, offset, block)
+		})
+	}
+
+	// Meta keeps the last indexed block, so it is replayed from there
+	index.behind = err != nil
+
+	if err != nil {
+		GetLogger().Errorf("Error updating index with block %x %s", block.BlockHash, err.Error())
+	}
+}
+
+// Adds blocks after the last indexed one till the end of data file
+func (index *BoltIndex) catchUp() error {
+	offset, err := index.lastOffset()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := index.file.Seek(offset, 0); err != nil {
+		return err
+	}
+
+	blockCount, err := index.replay(index.file)
+	GetLogger().Infof("BoltIndex has caught up with %d blocks", blockCount)
+
+	return err
+}
+
+// Close closes index database
+func (index *BoltIndex) Close() error {
+	return index.db.Close()
+}
@@ -0,0 +1,120 @@
+package minichain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltIndexReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 4)
+	lastSize := len(encodeRecord(t, blocks[3]))
+	dbFile := filepath.Join(dir, "blockchain.db")
+
+	// Database covers the first three blocks
+	index, offset, err := NewBoltIndex(bytes.NewReader(data[:len(data)-lastSize]), dbFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offset != int64(len(data)-lastSize) {
+		t.Errorf("Expected offset %d actual %d", len(data)-lastSize, offset)
+	}
+
+	index.(*BoltIndex).Close()
+
+	_, otherData := buildChain(t, 2)
+
+	testData := []struct {
+		Name       string
+		Data       []byte
+		BlockCount int
+	}{
+		{
+			Name:       "append",
+			Data:       data,
+			BlockCount: 4,
+		},
+		{
+			// Data file was replaced, index has to be rebuilt
+			Name:       "rebuild",
+			Data:       otherData,
+			BlockCount: 2,
+		},
+	}
+
+	for _, testCase := range testData {
+		index, offset, err := NewBoltIndex(bytes.NewReader(testCase.Data), dbFile)
+
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.Name, err)
+		}
+
+		if offset != int64(len(testCase.Data)) {
+			t.Errorf("%s: expected offset %d actual %d", testCase.Name, len(testCase.Data), offset)
+		}
+
+		txs, _, _, err := index.Scan("", "", Cursor{}, DEFAULT_SEARCH_LIMIT)
+
+		if err != nil {
+			t.Errorf("%s: %v", testCase.Name, err)
+		}
+
+		if len(txs) != testCase.BlockCount {
+			t.Errorf("%s: expected %d transactions actual %d", testCase.Name, testCase.BlockCount, len(txs))
+		}
+
+		txs, _, err = index.Get("key1", Cursor{}, DEFAULT_SEARCH_LIMIT)
+
+		if err != nil || len(txs) != 1 {
+			t.Errorf("%s: key %s not found %v", testCase.Name, "key1", err)
+		}
+
+		index.(*BoltIndex).Close()
+	}
+}
+
+func TestBoltIndexUpdateAfterFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 3)
+	firstSize := len(encodeRecord(t, blocks[0]))
+	reader := bytes.NewReader(data)
+
+	index, _, err := NewBoltIndex(bytes.NewReader(data[:firstSize]), filepath.Join(dir, "blockchain.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.(*BoltIndex).Close()
+
+	// Update of the second block failed, the third one has to bring it back
+	index.(*BoltIndex).file = reader
+	index.(*BoltIndex).behind = true
+	index.Update(int64(len(data)-len(encodeRecord(t, blocks[2]))), blocks[2])
+
+	txs, _, _, err := index.Scan("", "", Cursor{}, DEFAULT_SEARCH_LIMIT)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != 3 || index.(*BoltIndex).behind {
+		t.Errorf("Expected %d transactions actual %d", 3, len(txs))
+	}
+}
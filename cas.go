@@ -0,0 +1,201 @@
+package minichain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+/*
+	Compare-and-set transaction sets value of key only if current value of
+	the key or id of its last transaction matches expected one, or only if
+	the key is absent. Condition is checked by blockchain when transaction is
+	added to the batch against committed value of the key with transactions
+	of the batch applied, so conflicting transactions of the same batch are
+	rejected as well.
+
+	Committed values are read off the blockchain loop, since without index
+	it takes a scan of the whole data file. Blocks flushed meanwhile are
+	applied by the loop before the check.
+*/
+
+// Transaction of request does not match current state of its key
+type ConflictError struct {
+	Key string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("key %s does not match expected value", e.Key)
+}
+
+// Returns value of key after transaction is applied to value
+func applyTransaction(value []byte, tx *Transaction) []byte {
+	switch tx.Type() {
+	case OP_APPEND:
+		return append(append([]byte{}, value...), tx.Value...)
+	case OP_DELETE:
+		return nil
+	default:
+		return tx.Value
+	}
+}
+
+// Committed value of key, id of its last transaction and whether key exists
+type keyState struct {
+	value  []byte
+	id     []byte
+	exists bool
+}
+
+// Committed state of keys of compare-and-set transactions of request read
+// off the blockchain loop at data file offset and height
+type casCheck struct {
+	request *CommitRequest
+	offset  int64
+	height  uint64
+	states  map[string]keyState
+	err     error
+}
+
+// Returns whether request has compare-and-set transaction
+func hasCompareAndSet(transactions []Transaction) bool {
+	for i := range transactions {
+		if transactions[i].Type() == OP_CAS {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads committed state of keys of compare-and-set transactions of request
+// as of height and sends it back to blockchain loop, reading of state may
+// take a full scan of data file, so it is done off the loop.
+func (b *BlockChain) readStates(request *CommitRequest, offset int64, height uint64) {
+	check := &casCheck{
+		request: request,
+		offset:  offset,
+		height:  height,
+		states:  make(map[string]keyState),
+	}
+
+	for _, tx := range request.Transactions {
+		if _, ok := check.states[tx.Key]; ok || tx.Type() != OP_CAS {
+			continue
+		}
+
+		state, err := b.committedState(tx.Key, height)
+
+		if err != nil {
+			check.err = err
+			break
+		}
+
+		check.states[tx.Key] = state
+	}
+
+	b.checked <- check
+}
+
+// Returns state of key after the first height blocks of chain
+func (b *BlockChain) committedState(key string, height uint64) (keyState, error) {
+	var state keyState
+
+	if height == 0 {
+		return state, nil
+	}
+
+	versions, _, err := b.state(&StateRequest{Key: key, At: &StatePoint{Height: height - 1}})
+
+	switch err {
+	case nil:
+		state = keyState{versions[0].Value, versions[0].Id, true}
+	case KeyDeletedErr:
+		state.id = versions[0].Id
+	case KeyNotFoundErr:
+	default:
+		return state, err
+	}
+
+	return state, nil
+}
+
+// Applies blocks flushed after state was read, so state is the same as the
+// one of the last flushed block. Called by blockchain loop, only few blocks
+// are read.
+func (b *BlockChain) updateStates(check *casCheck) error {
+	if check.offset == b.offset {
+		return nil
+	}
+
+	f, err := os.Open(b.dataFileName)
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(check.offset, 0); err != nil {
+		return err
+	}
+
+	// Blocks are written by the loop only, so file ends at current offset
+	_, err = replay(f, func(offset int64, height uint64, block *Block) {
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+
+			if state, ok := check.states[tx.Key]; ok {
+				check.states[tx.Key] = keyState{applyTransaction(state.value, tx), tx.Id, !tx.IsDelete()}
+			}
+		}
+	})
+
+	return err
+}
+
+// Checks conditions of compare-and-set transactions of request against
+// committed states of their keys, pending transactions of the batch are
+// applied on top of committed values.
+func (b *BlockChain) check(pending []Transaction, transactions []Transaction, states map[string]keyState) error {
+	for i := range transactions {
+		tx := &transactions[i]
+
+		if tx.Type() != OP_CAS {
+			continue
+		}
+
+		// Transactions of request before this one are pending as well
+		batch := append(pending[:len(pending):len(pending)], transactions[:i]...)
+		state := states[tx.Key]
+
+		for j := range batch {
+			if batch[j].Key == tx.Key {
+				state = keyState{applyTransaction(state.value, &batch[j]), batch[j].Id, !batch[j].IsDelete()}
+			}
+		}
+
+		if !tx.matches(state) {
+			return &ConflictError{tx.Key}
+		}
+	}
+
+	return nil
+}
+
+// Returns whether compare-and-set transaction can be applied to key
+func (tx *Transaction) matches(state keyState) bool {
+	if tx.ExpectAbsent {
+		return !state.exists
+	}
+
+	if len(tx.ExpectedId) != 0 && !bytes.Equal(tx.ExpectedId, state.id) {
+		return false
+	}
+
+	// Expected id alone does not require value
+	if len(tx.ExpectedId) != 0 && len(tx.Expected) == 0 {
+		return true
+	}
+
+	return state.exists && bytes.Equal(tx.Expected, state.value)
+}
@@ -0,0 +1,161 @@
+package minichain
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestBlockChainCompareAndSet(t *testing.T) {
+	for _, indexOn := range []bool{true, false} {
+		blockChain, cleanup := newTestBlockChain(t, 10, indexOn)
+
+		put := NewTransaction("key", []byte("value1"))
+
+		if err := blockChain.flush([]Transaction{*put, *NewTransaction("empty", []byte{})}); err != nil {
+			t.Fatal(err)
+		}
+
+		states := func(transactions ...Transaction) map[string]keyState {
+			states := make(map[string]keyState)
+
+			for _, tx := range transactions {
+				state, err := blockChain.committedState(tx.Key, 1)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				states[tx.Key] = state
+			}
+
+			return states
+		}
+
+		pending := []Transaction{*NewTypedTransaction(OP_APPEND, "key", []byte("2"))}
+
+		testData := []struct {
+			Name     string
+			Tx       *Transaction
+			Pending  []Transaction
+			Conflict bool
+		}{
+			{
+				Name: "expected value",
+				Tx:   NewCompareAndSetTransaction("key", []byte("value2"), []byte("value1"), nil),
+			},
+			{
+				Name:     "wrong value",
+				Tx:       NewCompareAndSetTransaction("key", []byte("value2"), []byte("value0"), nil),
+				Conflict: true,
+			},
+			{
+				Name: "expected id",
+				Tx:   NewCompareAndSetTransaction("key", []byte("value2"), nil, put.Id),
+			},
+			{
+				Name:     "key exists",
+				Tx:       NewCompareAndSetAbsentTransaction("key", []byte("value2")),
+				Conflict: true,
+			},
+			{
+				Name: "key is absent",
+				Tx:   NewCompareAndSetAbsentTransaction("other", []byte("value")),
+			},
+			{
+				Name: "empty value",
+				Tx:   NewCompareAndSetTransaction("empty", []byte("value"), nil, nil),
+			},
+			{
+				Name:     "empty value of absent key",
+				Tx:       NewCompareAndSetTransaction("other", []byte("value"), nil, nil),
+				Conflict: true,
+			},
+			{
+				Name:    "pending value",
+				Tx:      NewCompareAndSetTransaction("key", []byte("value3"), []byte("value12"), nil),
+				Pending: pending,
+			},
+			{
+				Name:     "pending id",
+				Tx:       NewCompareAndSetTransaction("key", []byte("value3"), nil, put.Id),
+				Pending:  pending,
+				Conflict: true,
+			},
+		}
+
+		for _, test := range testData {
+			err := blockChain.check(test.Pending, []Transaction{*test.Tx}, states(*test.Tx))
+
+			if _, conflict := err.(*ConflictError); conflict != test.Conflict {
+				t.Errorf("%s: expected conflict %v actual error %v", test.Name, test.Conflict, err)
+			}
+		}
+
+		// The second transaction of the same request sees the first one
+		request := []Transaction{
+			*NewCompareAndSetTransaction("key", []byte("value2"), []byte("value1"), nil),
+			*NewCompareAndSetTransaction("key", []byte("value3"), []byte("value1"), nil),
+		}
+		err := blockChain.check(nil, request, states(request...))
+
+		if _, ok := err.(*ConflictError); !ok {
+			t.Errorf("Expected conflict of request transactions actual %v", err)
+		}
+
+		cleanup()
+	}
+}
+
+func TestBlockChainCompareAndSetReject(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 10, true)
+	defer cleanup()
+
+	other := NewTransaction("other", []byte("value"))
+	resultChan := make(chan error, 1)
+	blockChain.Commit <- &CommitRequest{
+		Transactions: []Transaction{
+			*other,
+			*NewCompareAndSetTransaction("key", []byte("value"), []byte("value0"), nil),
+		},
+		ResultChan: resultChan,
+	}
+
+	if _, ok := (<-resultChan).(*ConflictError); !ok {
+		t.Errorf("Conflict is not reported")
+	}
+
+	// Rejected request is not added to the batch
+	resultChan2 := make(chan *StatusResult)
+	blockChain.Status <- &StatusRequest{context.Background(), other.Id, resultChan2}
+
+	if result := <-resultChan2; result.Status != TX_UNKNOWN {
+		t.Errorf("Expected status %s actual %s", TX_UNKNOWN, result.Status)
+	}
+}
+
+func TestBlockChainCompareAndSetFlushedAfterRead(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 10, false)
+	defer cleanup()
+
+	// State has been read before the block was flushed
+	check := &casCheck{
+		offset: blockChain.offset,
+		height: blockChain.height,
+		states: map[string]keyState{"key": {}},
+	}
+
+	put := NewTransaction("key", []byte("value1"))
+
+	if err := blockChain.flush([]Transaction{*put}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blockChain.updateStates(check); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := check.states["key"]; !state.exists || string(state.value) != "value1" || !bytes.Equal(state.id, put.Id) {
+		t.Errorf("Flushed block is not applied to state %v", state)
+	}
+}
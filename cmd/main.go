@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	. "github.com/stgleb/minichain"
@@ -10,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -44,32 +47,135 @@ func main() {
 	}
 
 	InitLogger(config.Main.LogLevel)
-	blockChainServer, err := NewBlockChainServer(config)
+
+	if flag.Arg(0) == "verify" {
+		verify(config)
+		return
+	}
+
+	// Handlers time out after Timeout, server waits a bit longer
+	server := &http.Server{
+		ReadTimeout:  time.Duration(config.Http.Timeout)*time.Second + HTTP_TIMEOUT_MARGIN,
+		WriteTimeout: time.Duration(config.Http.Timeout)*time.Second + HTTP_TIMEOUT_MARGIN,
+	}
+
+	l, err := net.Listen("tcp", config.Http.ListenStr)
 
 	if err != nil {
 		GetLogger().Fatal(err)
 	}
 
-	http.HandleFunc("/tx", blockChainServer.TransactionHandler)
-	http.HandleFunc("/search", blockChainServer.SearchByKey)
-	http.Handle("/metrics", promhttp.Handler())
+	if len(config.Http.CertFile) != 0 {
+		reloader, err := NewTLSReloader(config.Http)
 
-	server := &http.Server{
-		ReadTimeout:  time.Duration(config.Http.Timeout) * time.Second,
-		WriteTimeout: time.Duration(config.Http.Timeout) * time.Second,
+		if err != nil {
+			GetLogger().Fatal(err)
+		}
+
+		l = tls.NewListener(l, reloader.TLSConfig())
+		RegisterReloadHandler(reloader)
 	}
 
-	l, err := net.Listen("tcp", config.Http.ListenStr)
-	RegisterShutDownHandler(server, blockChainServer)
+	// Probes are answered while index is being built, node is not ready
+	// until blockchain is started and API requests get 503 till then
+	readiness := &Readiness{}
+	metricsHandler := promhttp.Handler()
+	http.HandleFunc("/healthz", HealthHandler)
+	http.HandleFunc("/readyz", readiness.Handler)
+	http.HandleFunc("/tx", readiness.HandlerFunc((*BlockChainServer).TransactionHandler))
+	http.HandleFunc("/search", readiness.HandlerFunc((*BlockChainServer).SearchByKey))
+	http.HandleFunc("/tx/batch", readiness.HandlerFunc((*BlockChainServer).BatchHandler))
+	http.HandleFunc("/tx/", readiness.HandlerFunc((*BlockChainServer).TransactionByIdHandler))
+	http.HandleFunc("/block/", readiness.HandlerFunc((*BlockChainServer).BlockHandler))
+	http.HandleFunc("/proof", readiness.HandlerFunc((*BlockChainServer).ProofHandler))
+	http.HandleFunc("/kv/", readiness.HandlerFunc((*BlockChainServer).KeyValueHandler))
+	http.HandleFunc("/status", readiness.HandlerFunc(func(blockChainServer *BlockChainServer, w http.ResponseWriter, r *http.Request) {
+		blockChainServer.Authenticated(http.HandlerFunc(blockChainServer.StatusHandler)).ServeHTTP(w, r)
+	}))
+	http.HandleFunc("/metrics", readiness.HandlerFunc(func(blockChainServer *BlockChainServer, w http.ResponseWriter, r *http.Request) {
+		blockChainServer.Authenticated(metricsHandler).ServeHTTP(w, r)
+	}))
+
+	// SIGINT during index build stops server as well
+	stoppedChan := make(chan struct{})
+	RegisterShutDownHandler(server, readiness, stoppedChan)
+
+	go func() {
+		blockChainServer, err := NewBlockChainServer(config)
+
+		if err != nil {
+			GetLogger().Fatal(err)
+		}
+
+		if err := RegisterMempoolMetrics(blockChainServer.BlockChain); err != nil {
+			GetLogger().Fatal(err)
+		}
+
+		readiness.SetServer(blockChainServer)
+		GetLogger().Info("Blockchain is ready")
+	}()
 
 	GetLogger().Infof("Listen and serve %s", config.Http.ListenStr)
-	if err := server.Serve(l); err != nil {
+	if err := server.Serve(l); err != http.ErrServerClosed {
 		GetLogger().Fatal(err)
 	}
+
+	// Wait for blockchain to flush pending transactions
+	<-stoppedChan
 }
 
-func RegisterShutDownHandler(server *http.Server, blockChainServer *BlockChainServer) {
-	stopChan := make(chan os.Signal)
+// Verify integrity of blockchain data file and exit with non-zero code
+// if any record is broken.
+func verify(config *Config) {
+	f, err := os.Open(config.BlockChain.DataFile)
+
+	if err != nil {
+		GetLogger().Fatal(err)
+	}
+	defer f.Close()
+
+	var policy *SignerPolicy
+
+	if len(config.BlockChain.SignersFile) != 0 {
+		if policy, err = LoadSignerPolicy(config.BlockChain.SignersFile); err != nil {
+			GetLogger().Fatal(err)
+		}
+	}
+
+	blockCount, err := VerifyChain(f, policy)
+
+	if err != nil {
+		GetLogger().Errorf("Blockchain %s is broken after %d valid blocks: %v",
+			config.BlockChain.DataFile, blockCount, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Blockchain %s is valid, %d blocks verified\n",
+		config.BlockChain.DataFile, blockCount)
+}
+
+// Reloads TLS certificates on SIGHUP, blockchain keeps running and failed
+// reload keeps previous certificates
+func RegisterReloadHandler(reloader *TLSReloader) {
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	go func() {
+		for range reloadChan {
+			GetLogger().Info("Reloading TLS certificates...")
+
+			if err := reloader.Reload(); err != nil {
+				GetLogger().Errorf("TLS certificates have not been reloaded: %v", err)
+			}
+		}
+	}()
+}
+
+// Stops server and blockchain on SIGINT, stoppedChan is closed when
+// blockchain has been stopped. Blockchain that is not built yet has nothing
+// to flush, so only server is stopped.
+func RegisterShutDownHandler(server *http.Server, readiness *Readiness, stoppedChan chan struct{}) {
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt)
 
 	go func() {
@@ -81,6 +187,14 @@ func RegisterShutDownHandler(server *http.Server, blockChainServer *BlockChainSe
 
 		server.Shutdown(ctx)
 
+		blockChainServer := readiness.Server()
+
+		if blockChainServer == nil {
+			GetLogger().Info("Server has been stopped before blockchain is ready")
+			close(stoppedChan)
+			return
+		}
+
 		shutDownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
 
@@ -95,5 +209,6 @@ func RegisterShutDownHandler(server *http.Server, blockChainServer *BlockChainSe
 		}
 
 		GetLogger().Info("Server has been stopped")
+		close(stoppedChan)
 	}()
 }
@@ -5,6 +5,7 @@ type Config struct {
 	BlockChain BlockChainConfig
 	Index      IndexConfig
 	Http       HttpConfig
+	Auth       AuthConfig
 }
 
 type MainConfig struct {
@@ -17,14 +18,61 @@ type BlockChainConfig struct {
 	KeyMaxSize   int
 	ValueMaxSize int
 	DataFile     string
+	// Wait for transaction to be synced to disk before response by default
+	WaitSync bool
+	// Count of commit requests that wait for blockchain, requests are
+	// rejected when mempool is full
+	MempoolSize int
+	// File with signers allowed to write namespaces of keys
+	SignersFile string
 }
 
 type IndexConfig struct {
 	IndexType string
 	IsOn      bool
+	// File to save index to periodically and on shutdown and load it from on
+	// start
+	SnapshotFile string
+	// Seconds between snapshots of index
+	SnapshotInterval int
+	// Keep versions of every key in memory, otherwise versions of key are
+	// read from blocks that index points to
+	StateIndex bool
+	// File that keeps bloom filter of every block next to data file
+	FilterFile string
+	// False positive rate that bloom filter of block is sized for
+	FalsePositiveRate float64
+	// File of embedded database that keeps Bolt index
+	DBFile string
 }
 
 type HttpConfig struct {
 	ListenStr string
 	Timeout   int64
+	// Server serves HTTPS if certificate and key are set, files are read
+	// again on SIGHUP
+	CertFile string
+	KeyFile  string
+	// CA that client certificates are verified against
+	ClientCAFile string
+	// Reject clients without certificate signed by client CA (mTLS)
+	RequireClientCert bool
+	// Transactions per second allowed to each client, 0 turns limit off
+	RateLimit float64
+	// Transactions that client can send at once after being idle
+	RateBurst int
+}
+
+type AuthConfig struct {
+	IsOn  bool
+	Token []TokenConfig
+}
+
+// Token is scoped to key prefixes and to read and write permissions
+type TokenConfig struct {
+	Name     string
+	Token    string
+	Prefixes []string
+	Read     bool
+	Write    bool
 }
@@ -0,0 +1,99 @@
+package minichain
+
+import (
+	"io"
+	"sync"
+)
+
+/*
+	Hash index maps block hashes and transaction ids to location of the block
+	in blockchain file. It is maintained next to key index and allows to read
+	transaction or block by its hash with one disk read.
+
+	Example:
+
+	blockchain
+
+	0	block0(hash: aa): tx{id: 01}, tx{id: 02}
+	28	block1(hash: bb): tx{id: 03}
+
+	Index
+
+		blocks
+			aa - {offset: 0, height: 0}
+			bb - {offset: 28, height: 1}
+		transactions
+			01 - {offset: 0, height: 0}
+			02 - {offset: 0, height: 0}
+			03 - {offset: 28, height: 1}
+*/
+
+type BlockLocation struct {
+	Offset int64  `json:"offset"`
+	Height uint64 `json:"height"`
+}
+
+type HashIndex struct {
+	// Mutex protects maps from races
+	m            sync.RWMutex
+	blocks       map[string]BlockLocation
+	transactions map[string]BlockLocation
+}
+
+func NewHashIndex(file io.ReadSeeker) (*HashIndex, error) {
+	GetLogger().Info("Start building hash index")
+
+	index := newHashIndex()
+	height, err := replay(file, index.Update)
+
+	if err != nil {
+		return nil, err
+	}
+
+	GetLogger().Debugf("HashIndex has been built from %d blocks", height)
+	return index, nil
+}
+
+func newHashIndex() *HashIndex {
+	return &HashIndex{
+		blocks:       make(map[string]BlockLocation),
+		transactions: make(map[string]BlockLocation),
+	}
+}
+
+// Update index with new block
+func (index *HashIndex) Update(offset int64, height uint64, block *Block) {
+	location := BlockLocation{
+		Offset: offset,
+		Height: height,
+	}
+
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	index.blocks[string(block.BlockHash)] = location
+
+	for _, tx := range block.Transactions {
+		index.transactions[string(tx.Id)] = location
+	}
+}
+
+// Returns location of block with hash
+func (index *HashIndex) Block(hash []byte) (BlockLocation, bool) {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	location, ok := index.blocks[string(hash)]
+
+	return location, ok
+}
+
+// Returns location of block that contains transaction with id
+func (index *HashIndex) Transaction(id []byte) (BlockLocation, bool) {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	location, ok := index.transactions[string(id)]
+
+	return location, ok
+}
@@ -0,0 +1,38 @@
+package minichain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewHashIndex(t *testing.T) {
+	blocks, data := buildChain(t, 3)
+
+	index, err := NewHashIndex(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var offset int64
+
+	for i, block := range blocks {
+		expected := BlockLocation{offset, uint64(i)}
+		offset += int64(len(encodeRecord(t, block)))
+		location, ok := index.Block(block.BlockHash)
+
+		if !ok || location != expected {
+			t.Errorf("Expected block location %v actual %v", expected, location)
+		}
+
+		location, ok = index.Transaction(block.Transactions[0].Id)
+
+		if !ok || location != expected {
+			t.Errorf("Expected tx location %v actual %v", expected, location)
+		}
+	}
+
+	if _, ok := index.Block([]byte("unknown")); ok {
+		t.Errorf("Unknown block hash was found in index")
+	}
+}
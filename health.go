@@ -0,0 +1,133 @@
+package minichain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Blockchain loop that doesn't answer in this time is considered stuck
+	READINESS_TIMEOUT = time.Second
+
+	HEALTH_OK        = "ok"
+	HEALTH_NOT_READY = "not ready"
+)
+
+var NotReadyErr = errors.New("blockchain is not ready")
+
+type HealthResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Liveness probe, process is alive while it serves HTTP
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", JSON_CONTENT_TYPE)
+	json.NewEncoder(w).Encode(&HealthResult{Status: HEALTH_OK})
+}
+
+// Readiness answers readiness probe while blockchain server is being built,
+// index replay can take long time and node should not get traffic until
+// it finishes.
+type Readiness struct {
+	m      sync.RWMutex
+	server *BlockChainServer
+}
+
+// Marks node ready to check blockchain loop of server
+func (readiness *Readiness) SetServer(server *BlockChainServer) {
+	readiness.m.Lock()
+	defer readiness.m.Unlock()
+
+	readiness.server = server
+}
+
+// Returns blockchain server, nil until it is built
+func (readiness *Readiness) Server() *BlockChainServer {
+	readiness.m.RLock()
+	defer readiness.m.RUnlock()
+
+	return readiness.server
+}
+
+func (readiness *Readiness) Handler(w http.ResponseWriter, r *http.Request) {
+	readiness.HandlerFunc((*BlockChainServer).ReadyHandler)(w, r)
+}
+
+// Wraps handler method of blockchain server, so handlers can be registered
+// before server is built. Requests get 503 until server is set.
+func (readiness *Readiness) HandlerFunc(handler func(*BlockChainServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		server := readiness.Server()
+
+		if server == nil {
+			notReady(w, NotReadyErr)
+			return
+		}
+
+		handler(server, w, r)
+	}
+}
+
+// Node is ready when blockchain loop answers status request
+func (blockChainServer *BlockChainServer) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), READINESS_TIMEOUT)
+	defer cancel()
+
+	if _, err := blockChainServer.chainStatus(ctx); err != nil {
+		notReady(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", JSON_CONTENT_TYPE)
+	json.NewEncoder(w).Encode(&HealthResult{Status: HEALTH_OK})
+}
+
+func notReady(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", JSON_CONTENT_TYPE)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(&HealthResult{
+		Status: HEALTH_NOT_READY,
+		Error:  err.Error(),
+	})
+}
+
+// Returns height, last block hash, data file size, index type, size of
+// pending batch and time since the last flush
+func (blockChainServer *BlockChainServer) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
+	defer cancel()
+
+	status, err := blockChainServer.chainStatus(ctx)
+
+	if err != nil {
+		http.Error(w, "status request timed out", http.StatusGatewayTimeout)
+		return
+	}
+
+	w.Header().Set("Content-Type", JSON_CONTENT_TYPE)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (blockChainServer *BlockChainServer) chainStatus(ctx context.Context) (*ChainStatus, error) {
+	req := &ChainStatusRequest{
+		ResultChan: make(chan *ChainStatus, 1),
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case blockChainServer.BlockChain.Info <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case status := <-req.ResultChan:
+		return status, nil
+	}
+}
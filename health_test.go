@@ -0,0 +1,97 @@
+package minichain
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	readiness := &Readiness{}
+
+	w := httptest.NewRecorder()
+	readiness.Handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Node without blockchain is ready, response code %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	readiness.HandlerFunc((*BlockChainServer).TransactionHandler)(w, httptest.NewRequest(http.MethodGet, "/tx?key=key&value=value", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Transaction is handled without blockchain, response code %d", w.Code)
+	}
+
+	blockChain, cleanup := newTestBlockChain(t, 2, true)
+	readiness.SetServer(&BlockChainServer{
+		Timeout:    time.Second,
+		BlockChain: blockChain,
+	})
+
+	w = httptest.NewRecorder()
+	readiness.Handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Node with running blockchain is not ready, response code %d", w.Code)
+	}
+
+	// Loop doesn't answer after shutdown
+	cleanup()
+
+	w = httptest.NewRecorder()
+	readiness.Handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Node with stopped blockchain is ready, response code %d", w.Code)
+	}
+}
+
+func TestBlockChainServerStatus(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 2, true)
+	defer cleanup()
+
+	blockChainServer := &BlockChainServer{
+		Timeout:    time.Second,
+		BlockChain: blockChain,
+	}
+
+	req := &CommitRequest{
+		Transactions: []Transaction{
+			*NewTransaction("key1", []byte("value1")),
+			*NewTransaction("key2", []byte("value2")),
+		},
+		ResultChan: make(chan error, 1),
+	}
+	blockChain.Commit <- req
+
+	if err := <-req.ResultChan; err != nil {
+		t.Fatal(err)
+	}
+
+	blockChain.Input <- NewTransaction("key3", []byte("value3"))
+
+	w := httptest.NewRecorder()
+	blockChainServer.StatusHandler(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong response code expected %d actual %d", http.StatusOK, w.Code)
+	}
+
+	status := &ChainStatus{}
+
+	if err := json.NewDecoder(w.Body).Decode(status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Height != 1 || status.PendingTransactions != 1 || status.IndexType != INVERTED_INDEX {
+		t.Errorf("Wrong chain status %v", status)
+	}
+
+	if status.DataFileSize == 0 || !bytes.Equal(status.LastBlockHash, blockChain.lastBlockHash) {
+		t.Errorf("Wrong last block of chain status %v", status)
+	}
+}
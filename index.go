@@ -3,30 +3,182 @@ package minichain
 import (
 	"errors"
 	"io"
+	"sort"
 )
 
 const (
 	INVERTED_INDEX = "InvertedIndex"
 	BLOOM_FILTER   = "BloomFilter"
+	BOLT_INDEX     = "Bolt"
+	// Reported as index type when index is off
+	NO_INDEX = "None"
 )
 
 type Index interface {
-	Get(string) ([]Transaction, error)
+	// Returns at most limit transactions with key in chain order starting
+	// from cursor and cursor of the next page, nil if there are no more.
+	Get(key string, cursor Cursor, limit int) ([]Transaction, *Cursor, error)
+	// Returns at most limit transactions with keys in range [start, end)
+	// ordered by key and chain order, transactions of start key begin from
+	// cursor. Returns key and cursor of the next page, empty key if there are
+	// no more. Empty end means that range is not bounded.
+	Scan(start, end string, cursor Cursor, limit int) ([]Transaction, string, *Cursor, error)
+	// Returns offsets of blocks that may contain key in chain order
+	Offsets(key string) ([]int64, error)
 	Update(int64, *Block)
 }
 
+// Index that can be saved to snapshot file and loaded on start instead of
+// rebuilding it from the whole data file. Snapshot is a copy of index that
+// covers data file up to offset, it is written while index keeps updating.
+type PersistentIndex interface {
+	Snapshot(offset int64, lastBlockHash []byte) interface{}
+}
+
 var (
 	KeyNotFoundErr   = errors.New("key not found")
 	NotEnoughDataErr = errors.New("not enough data in reader")
+	TxNotFoundErr    = errors.New("transaction not found")
+	BlockNotFoundErr = errors.New("block not found")
+	LegacyBlockErr   = errors.New("block has no merkle root")
+	KeyDeletedErr    = errors.New("key has been deleted")
+	// Record on offset is not record of expected block
+	RecordMismatchErr = errors.New("record does not match block")
 )
 
-func NewIndex(reader io.ReadSeeker, indexType string) (Index, int64, error) {
-	switch indexType {
+// Builds index of configured type, if snapshot or filter file is set index
+// is loaded from it.
+func NewIndex(reader io.ReadSeeker, config IndexConfig) (Index, int64, error) {
+	switch config.IndexType {
 	case INVERTED_INDEX:
+		if len(config.SnapshotFile) != 0 {
+			return LoadInvertedIndex(reader, config.SnapshotFile)
+		}
+
 		return NewInvertedIndex(reader)
 	case BLOOM_FILTER:
-		return NewBloomFilterIndex(reader)
+		if len(config.FilterFile) != 0 {
+			return LoadBloomFilterIndex(reader, config.FilterFile, config.FalsePositiveRate)
+		}
+
+		return NewBloomFilterIndex(reader, config.FalsePositiveRate)
+	case BOLT_INDEX:
+		return NewBoltIndex(reader, config.DBFile)
 	default:
 		return nil, 0, nil
 	}
 }
+
+func keyInRange(key, start, end string) bool {
+	return key >= start && (len(end) == 0 || key < end)
+}
+
+// Returns the first key that is greater than all keys with prefix, so
+// prefix query becomes range query [prefix, prefixEnd(prefix)).
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	// Prefix consists of 0xff bytes only, range is not bounded
+	return ""
+}
+
+// Page of range scan that keeps at most limit transactions with the
+// smallest key and position in chain that follow start key and cursor, so
+// range can be paged without index ordered by key in bounded memory.
+type rangePage struct {
+	start  string
+	end    string
+	cursor Cursor
+	limit  int
+	// Transactions and their cursors ordered by key and position, one more
+	// than limit to tell the next page
+	transactions []Transaction
+	cursors      []Cursor
+}
+
+func newRangePage(start, end string, cursor Cursor, limit int) *rangePage {
+	return &rangePage{
+		start:        start,
+		end:          end,
+		cursor:       cursor,
+		limit:        limit,
+		transactions: make([]Transaction, 0),
+		cursors:      make([]Cursor, 0),
+	}
+}
+
+func (page *rangePage) add(block *Block, offset int64) {
+	for i, tx := range block.Transactions {
+		cursor := Cursor{offset, i}
+
+		if !keyInRange(tx.Key, page.start, page.end) ||
+			tx.Key == page.start && cursor.before(page.cursor) {
+			continue
+		}
+
+		j := sort.Search(len(page.transactions), func(j int) bool {
+			return tx.Key < page.transactions[j].Key ||
+				tx.Key == page.transactions[j].Key && cursor.before(page.cursors[j])
+		})
+
+		if j > page.limit {
+			continue
+		}
+
+		page.transactions = append(page.transactions, Transaction{})
+		copy(page.transactions[j+1:], page.transactions[j:])
+		page.transactions[j] = tx
+		page.cursors = append(page.cursors, Cursor{})
+		copy(page.cursors[j+1:], page.cursors[j:])
+		page.cursors[j] = cursor
+
+		if len(page.transactions) > page.limit+1 {
+			page.transactions = page.transactions[:page.limit+1]
+			page.cursors = page.cursors[:page.limit+1]
+		}
+	}
+}
+
+// Returns transactions of page with key and cursor of the next page, empty
+// key if there are no more.
+func (page *rangePage) result() ([]Transaction, string, *Cursor) {
+	if len(page.transactions) <= page.limit {
+		return page.transactions, "", nil
+	}
+
+	next := page.transactions[page.limit].Key
+	cursor := page.cursors[page.limit]
+
+	return page.transactions[:page.limit], next, &cursor
+}
+
+// Appends transactions of block that match to the page, transactions before
+// cursor are skipped. When page is full returns cursor of the next matching
+// transaction.
+func pageBlock(block *Block, offset int64, cursor Cursor, limit int, transactions []Transaction,
+	match func(*Transaction) bool) ([]Transaction, *Cursor) {
+	for i := range block.Transactions {
+		if offset == cursor.Offset && i < cursor.Position {
+			continue
+		}
+
+		if !match(&block.Transactions[i]) {
+			continue
+		}
+
+		if len(transactions) == limit {
+			return transactions, &Cursor{offset, i}
+		}
+
+		transactions = append(transactions, block.Transactions[i])
+	}
+
+	return transactions, nil
+}
@@ -0,0 +1,233 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	testData := []struct {
+		Prefix   string
+		Expected string
+	}{
+		{"order-", "order."},
+		{"a\xff", "b"},
+		{"\xff\xff", ""},
+	}
+
+	for _, test := range testData {
+		if end := prefixEnd(test.Prefix); end != test.Expected {
+			t.Errorf("Expected prefix end %q actual %q", test.Expected, end)
+		}
+	}
+}
+
+// Builds chain with keys spread between blocks for range queries
+func buildRangeChain(t *testing.T) []byte {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	prevHash := genesis[:]
+	data := make([]byte, 0)
+	keys := [][]string{
+		{"order-2", "other"},
+		{"order-1"},
+		{"order-3", "order-1", "apple"},
+	}
+
+	for height, blockKeys := range keys {
+		transactions := make([]Transaction, 0, len(blockKeys))
+
+		for _, key := range blockKeys {
+			transactions = append(transactions, *NewTransaction(key, []byte("value")))
+		}
+
+		block := NewBlock(uint64(height), prevHash, transactions)
+		data = append(data, encodeRecord(t, block)...)
+		prevHash = block.BlockHash
+	}
+
+	return data
+}
+
+// Returns index config that keeps index files in dir
+func testIndexConfig(dir, indexType string) IndexConfig {
+	return IndexConfig{
+		IndexType: indexType,
+		DBFile:    filepath.Join(dir, indexType+".db"),
+	}
+}
+
+func TestIndexScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, indexType := range []string{INVERTED_INDEX, BLOOM_FILTER, BOLT_INDEX} {
+		index, _, err := NewIndex(bytes.NewReader(buildRangeChain(t)), testIndexConfig(dir, indexType))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if closer, ok := index.(io.Closer); ok {
+			defer closer.Close()
+		}
+
+		txs, next, cursor, err := index.Scan("order-", prefixEnd("order-"), Cursor{}, 3)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(txs) != 3 || txs[0].Key != "order-1" || txs[1].Key != "order-1" || txs[2].Key != "order-2" {
+			t.Errorf("%s wrong first page of prefix scan %v", indexType, txs)
+		}
+
+		if next != "order-3" || cursor == nil {
+			t.Fatalf("%s expected next key %s actual %s", indexType, "order-3", next)
+		}
+
+		txs, next, _, err = index.Scan(next, prefixEnd("order-"), *cursor, 3)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(txs) != 1 || txs[0].Key != "order-3" || next != "" {
+			t.Errorf("%s wrong second page of prefix scan %v next %s", indexType, txs, next)
+		}
+
+		// Page ends in the middle of transactions of key
+		keys := make([]string, 0)
+		start, cursor := "order-", &Cursor{}
+
+		for cursor != nil {
+			txs, next, cursor, err = index.Scan(start, prefixEnd("order-"), *cursor, 1)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tx := range txs {
+				keys = append(keys, tx.Key)
+			}
+
+			if len(next) != 0 {
+				start = next
+			}
+		}
+
+		if strings.Join(keys, ",") != "order-1,order-1,order-2,order-3" {
+			t.Errorf("%s wrong pages of one transaction %v", indexType, keys)
+		}
+
+		txs, _, _, err = index.Scan("b", "p", Cursor{}, 10)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(txs) != 5 {
+			t.Errorf("%s expected %d transactions in range actual %d", indexType, 5, len(txs))
+		}
+	}
+}
+
+func TestRangeScan(t *testing.T) {
+	data := buildRangeChain(t)
+	txs, next, cursor, err := rangeScan("", "", Cursor{}, 2, bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != 2 || txs[0].Key != "apple" || txs[1].Key != "order-1" || next != "order-1" || cursor == nil {
+		t.Fatalf("Wrong page of range scan %v next %s", txs, next)
+	}
+
+	txs, next, _, err = rangeScan(next, "", *cursor, 2, bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != 2 || txs[0].Key != "order-1" || txs[1].Key != "order-2" || next != "order-3" {
+		t.Errorf("Wrong second page of range scan %v next %s", txs, next)
+	}
+}
+
+func TestIndexGetPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := buildRangeChain(t)
+
+	for _, indexType := range []string{INVERTED_INDEX, BLOOM_FILTER, BOLT_INDEX, ""} {
+		var get func(string, Cursor, int) ([]Transaction, *Cursor, error)
+
+		if len(indexType) == 0 {
+			get = func(key string, cursor Cursor, limit int) ([]Transaction, *Cursor, error) {
+				return fullScan(key, cursor, limit, bytes.NewReader(data))
+			}
+		} else {
+			index, _, err := NewIndex(bytes.NewReader(data), testIndexConfig(dir, indexType))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if closer, ok := index.(io.Closer); ok {
+				defer closer.Close()
+			}
+
+			get = index.Get
+		}
+
+		txs, cursor, err := get("order-1", Cursor{}, 1)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(txs) != 1 || cursor == nil || cursor.Position != 1 {
+			t.Fatalf("%s wrong first page %v cursor %v", indexType, txs, cursor)
+		}
+
+		txs, cursor, err = get("order-1", *cursor, 1)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(txs) != 1 || cursor != nil {
+			t.Errorf("%s wrong second page %v cursor %v", indexType, txs, cursor)
+		}
+	}
+}
+
+func TestCursorText(t *testing.T) {
+	cursor := Cursor{1024, 3}
+	text, _ := cursor.MarshalText()
+
+	var parsed Cursor
+
+	if err := parsed.UnmarshalText(text); err != nil || parsed != cursor {
+		t.Errorf("Expected cursor %v actual %v %v", cursor, parsed, err)
+	}
+
+	if err := parsed.UnmarshalText([]byte("-1:0")); err == nil {
+		t.Errorf("Negative cursor offset is accepted")
+	}
+}
@@ -2,6 +2,8 @@ package minichain
 
 import (
 	"io"
+	"os"
+	"sort"
 	"sync"
 )
 
@@ -30,6 +32,8 @@ type InvertedIndex struct {
 	//TODO(stgleb): Consider using sync.Map
 	m    sync.RWMutex
 	data map[string][]int64
+	// Sorted keys of data map for range queries
+	keys []string
 	file io.ReadSeeker
 }
 
@@ -41,75 +45,148 @@ func NewInvertedIndex(file io.ReadSeeker) (Index, int64, error) {
 		data: make(map[string][]int64),
 	}
 
-	var (
-		err        error
-		blockCount int64
-		offset     int64
-		block      *Block
-	)
-
-	for {
-		if block, offset, err = readBlock(file); err != nil {
-			if err != io.EOF {
-				return nil, 0, err
-			} else {
-				break
-			}
+	return index.replay(file)
+}
+
+type invertedIndexSnapshot struct {
+	SnapshotHeader
+	Data map[string][]int64
+}
+
+// Loads index from snapshot file and replays blocks written after snapshot,
+// index is built from the whole data file if snapshot is missing or broken.
+func LoadInvertedIndex(file io.ReadSeeker, snapshotFile string) (Index, int64, error) {
+	snapshot := &invertedIndexSnapshot{}
+	err := readSnapshot(snapshotFile, snapshot)
+
+	if err == nil {
+		err = seekSnapshot(file, snapshot.SnapshotHeader)
+	}
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			GetLogger().Infof("Index snapshot %s not found", snapshotFile)
 		} else {
-			GetLogger().Debugf("Read block id %s on offset %d",
-				string(block.BlockHash), offset)
-			for _, tx := range block.Transactions {
-				if index.data[string(tx.Key)] == nil {
-					index.data[string(tx.Key)] = []int64{offset}
-				} else {
-					index.data[string(tx.Key)] = append(index.data[string(tx.Key)], offset)
-				}
-			}
-			blockCount++
+			GetLogger().Warnf("Skip index snapshot %s: %v", snapshotFile, err)
+		}
+
+		if _, err := file.Seek(0, 0); err != nil {
+			return nil, 0, err
+		}
+
+		return NewInvertedIndex(file)
+	}
+
+	GetLogger().Infof("Load index snapshot %s that covers %d bytes", snapshotFile, snapshot.Offset)
+
+	index := &InvertedIndex{
+		file: file,
+		data: snapshot.Data,
+		keys: make([]string, 0, len(snapshot.Data)),
+	}
+
+	for key := range index.data {
+		index.keys = append(index.keys, key)
+	}
+
+	sort.Strings(index.keys)
+
+	return index.replay(file)
+}
+
+// Adds blocks from current position of file till the end to index
+func (index *InvertedIndex) replay(file io.ReadSeeker) (Index, int64, error) {
+	blockCount, err := replay(file, func(offset int64, height uint64, block *Block) {
+		GetLogger().Debugf("Read block id %x on offset %d", block.BlockHash, offset)
+
+		for _, tx := range block.Transactions {
+			index.insert(tx.Key, offset)
 		}
+	})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset, err := file.Seek(0, 1)
+
+	if err != nil {
+		return nil, 0, err
 	}
 
-	GetLogger().Debugf("InvertedIndex has been built from %d blocks", blockCount)
+	GetLogger().Debugf("InvertedIndex has been updated with %d blocks", blockCount)
 	return index, offset, nil
 }
 
-func (index *InvertedIndex) Get(key string) ([]Transaction, error) {
+// Snapshot copies offsets of keys, offsets are only appended, so copy of
+// map shares them with index.
+func (index *InvertedIndex) Snapshot(offset int64, lastBlockHash []byte) interface{} {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	data := make(map[string][]int64, len(index.data))
+
+	for key, offsets := range index.data {
+		data[key] = offsets[:len(offsets):len(offsets)]
+	}
+
+	return &invertedIndexSnapshot{
+		SnapshotHeader: SnapshotHeader{
+			Offset:        offset,
+			LastBlockHash: lastBlockHash,
+		},
+		Data: data,
+	}
+}
+
+func (index *InvertedIndex) Get(key string, cursor Cursor, limit int) ([]Transaction, *Cursor, error) {
 	// Protect Get method with Write lock since it modifies Seeker e.g fd state.
 	index.m.Lock()
 	defer index.m.Unlock()
 
-	offsets, ok := index.data[key]
-
-	if !ok {
-		return nil, KeyNotFoundErr
+	if _, ok := index.data[key]; !ok {
+		return nil, nil, KeyNotFoundErr
 	}
 
-	var (
-		err          error
-		transactions = make([]Transaction, 0, len(offsets))
-	)
+	return index.page(key, cursor, limit, make([]Transaction, 0))
+}
 
-	for _, offset := range offsets {
-		_, err = index.file.Seek(offset, 0)
+// Appends transactions of key starting from cursor to the page, returns
+// cursor of the next transaction of key when page is full.
+func (index *InvertedIndex) page(key string, cursor Cursor, limit int, transactions []Transaction) ([]Transaction, *Cursor, error) {
+	var next *Cursor
+	offsets := index.data[key]
 
-		if err != nil {
-			return nil, err
+	// Offsets are sorted since blocks are appended to the end of file
+	i := sort.Search(len(offsets), func(i int) bool {
+		return offsets[i] >= cursor.Offset
+	})
+
+	for ; i < len(offsets) && next == nil; i++ {
+		if _, err := index.file.Seek(offsets[i], 0); err != nil {
+			return nil, nil, err
 		}
 
 		block, _, err := readBlock(index.file)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		for _, tx := range block.Transactions {
-			if string(tx.Key) == key {
-				transactions = append(transactions, tx)
-			}
-		}
+		transactions, next = pageBlock(block, offsets[i], cursor, limit, transactions, func(tx *Transaction) bool {
+			return tx.Key == key
+		})
 	}
 
-	return transactions, nil
+	return transactions, next, nil
+}
+
+func (index *InvertedIndex) Offsets(key string) ([]int64, error) {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	// Offsets are only appended, copy protects from concurrent append
+	return append([]int64{}, index.data[key]...), nil
 }
 
 // Update index with new transactions
@@ -118,11 +195,65 @@ func (index *InvertedIndex) Update(offset int64, block *Block) {
 		index.m.Lock()
 		// This update on slice that stores key offsets is safe since we allow only
 		// one goroutine to update it.
-		if index.data[string(tx.Key)] == nil {
-			index.data[string(tx.Key)] = []int64{offset}
-		} else {
-			index.data[string(tx.Key)] = append(index.data[string(tx.Key)], offset)
-		}
+		index.insert(tx.Key, offset)
 		index.m.Unlock()
 	}
 }
+
+// Adds offset to the key, new key is inserted to sorted keys. Caller must
+// hold the lock.
+func (index *InvertedIndex) insert(key string, offset int64) {
+	offsets := index.data[key]
+
+	if offsets == nil {
+		i := sort.SearchStrings(index.keys, key)
+		index.keys = append(index.keys, "")
+		copy(index.keys[i+1:], index.keys[i:])
+		index.keys[i] = key
+	} else if offsets[len(offsets)-1] == offset {
+		// Key appears in the block several times
+		return
+	}
+
+	index.data[key] = append(offsets, offset)
+}
+
+func (index *InvertedIndex) Scan(start, end string, cursor Cursor, limit int) ([]Transaction, string, *Cursor, error) {
+	// Protect Scan method with Write lock since it modifies Seeker e.g fd state.
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	transactions := make([]Transaction, 0)
+
+	for i := sort.SearchStrings(index.keys, start); i < len(index.keys); i++ {
+		key := index.keys[i]
+
+		if !keyInRange(key, start, end) {
+			break
+		}
+
+		// Cursor points into transactions of the first key only
+		var keyCursor Cursor
+
+		if key == start {
+			keyCursor = cursor
+		}
+
+		var (
+			next *Cursor
+			err  error
+		)
+
+		transactions, next, err = index.page(key, keyCursor, limit, transactions)
+
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if next != nil {
+			return transactions, key, next, nil
+		}
+	}
+
+	return transactions, "", nil, nil
+}
@@ -23,13 +23,13 @@ func TestInvertedIndexGet(t *testing.T) {
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       key,
-				Value:     "value1",
+				Value:     []byte("value1"),
 			},
 			{
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       "key2",
-				Value:     "value2",
+				Value:     []byte("value2"),
 			},
 		},
 	}
@@ -57,7 +57,7 @@ func TestInvertedIndexGet(t *testing.T) {
 		},
 	}
 
-	txs, err := index.Get(key)
+	txs, _, err := index.Get(key, Cursor{}, DEFAULT_SEARCH_LIMIT)
 
 	if err != nil {
 		t.Error(err)
@@ -82,13 +82,13 @@ func TestInvertedIndexUpdate(t *testing.T) {
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       key,
-				Value:     "value1",
+				Value:     []byte("value1"),
 			},
 			{
 				Id:        []byte("dummy-txs-2"),
 				Timestamp: 1,
 				Key:       "key2",
-				Value:     "value2",
+				Value:     []byte("value2"),
 			},
 		},
 	}
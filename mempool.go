@@ -0,0 +1,85 @@
+package minichain
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+var DuplicateTransactionErr = errors.New("signed transaction has been accepted already")
+
+// Ids of transactions accepted by blockchain that are not flushed yet: they
+// wait in mempool, for compare-and-set check or in the batch. The same id
+// can be accepted several times, so ids are counted. Zero value is ready to
+// use.
+type pendingSet struct {
+	m   sync.Mutex
+	ids map[string]int
+}
+
+// Adds transactions unless signed one is pending or committed already, such
+// transaction is replay of transaction seen by somebody else. Committed
+// transactions are checked only if committed function is set.
+func (pending *pendingSet) add(transactions []Transaction, committed func([]byte) (bool, error)) error {
+	pending.m.Lock()
+	defer pending.m.Unlock()
+
+	if pending.ids == nil {
+		pending.ids = make(map[string]int)
+	}
+
+	for i, tx := range transactions {
+		if len(tx.Signature) == 0 || committed == nil {
+			continue
+		}
+
+		if pending.ids[string(tx.Id)] != 0 || hasTransaction(transactions[:i], tx.Id) {
+			return DuplicateTransactionErr
+		}
+
+		// Transaction is removed from pending ones after it is flushed, so
+		// one of checks finds it
+		if ok, err := committed(tx.Id); err != nil {
+			return err
+		} else if ok {
+			return DuplicateTransactionErr
+		}
+	}
+
+	for _, tx := range transactions {
+		pending.ids[string(tx.Id)]++
+	}
+
+	return nil
+}
+
+// Removes transactions that have been flushed or rejected
+func (pending *pendingSet) remove(transactions []Transaction) {
+	pending.m.Lock()
+	defer pending.m.Unlock()
+
+	for _, tx := range transactions {
+		if pending.ids[string(tx.Id)] <= 1 {
+			delete(pending.ids, string(tx.Id))
+		} else {
+			pending.ids[string(tx.Id)]--
+		}
+	}
+}
+
+func (pending *pendingSet) contains(txId []byte) bool {
+	pending.m.Lock()
+	defer pending.m.Unlock()
+
+	return pending.ids[string(txId)] != 0
+}
+
+func hasTransaction(transactions []Transaction, txId []byte) bool {
+	for _, tx := range transactions {
+		if bytes.Equal(tx.Id, txId) {
+			return true
+		}
+	}
+
+	return false
+}
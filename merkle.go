@@ -0,0 +1,167 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+/*
+	Merkle tree built over transaction ids of the block.
+
+	Leaves and inner nodes are hashed with different prefixes, so leaf can't
+	be presented as inner node. Node without pair is moved to the next level
+	as is.
+
+	               root
+	             /      \
+	         h01          h2
+	        /   \          |
+	      h0     h1        h2
+	      |      |         |
+	     tx0    tx1       tx2
+*/
+
+const (
+	MERKLE_LEAF_PREFIX = 0x00
+	MERKLE_NODE_PREFIX = 0x01
+)
+
+func merkleLeaf(data []byte) []byte {
+	hash := sha256.Sum256(append([]byte{MERKLE_LEAF_PREFIX}, data...))
+	return hash[:]
+}
+
+func merkleNode(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, MERKLE_NODE_PREFIX)
+	data = append(data, left...)
+	data = append(data, right...)
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// Builds next level of the tree from the current one
+func merkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, merkleNode(level[i], level[i+1]))
+		}
+	}
+
+	return next
+}
+
+// Computes merkle root of transactions ids
+func merkleRoot(transactions []Transaction) []byte {
+	if len(transactions) == 0 {
+		hash := sha256.Sum256([]byte{})
+		return hash[:]
+	}
+
+	level := make([][]byte, 0, len(transactions))
+
+	for _, tx := range transactions {
+		level = append(level, merkleLeaf(tx.Id))
+	}
+
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+
+	return level[0]
+}
+
+// Sibling of the node on the path from leaf to root
+type ProofStep struct {
+	Hash []byte `json:"hash"`
+	// Sibling is on the left side of the node
+	Left bool `json:"left"`
+}
+
+// Block header fields that are needed to check block hash
+type BlockHeader struct {
+	Version       uint32 `json:"version"`
+	Height        uint64 `json:"height"`
+	Timestamp     int64  `json:"timestamp"`
+	PrevBlockHash []byte `json:"prev-block-hash"`
+	MerkleRoot    []byte `json:"merkle-root"`
+	BlockHash     []byte `json:"block-hash"`
+}
+
+// Proof that transaction with id is included in block
+type InclusionProof struct {
+	TxId  []byte      `json:"tx-id"`
+	Path  []ProofStep `json:"path"`
+	Block BlockHeader `json:"block"`
+}
+
+// Builds path of siblings from leaf with index to the root of tree
+func merkleProof(transactions []Transaction, index int) []ProofStep {
+	path := make([]ProofStep, 0)
+	level := make([][]byte, 0, len(transactions))
+
+	for _, tx := range transactions {
+		level = append(level, merkleLeaf(tx.Id))
+	}
+
+	for len(level) > 1 {
+		// Node without pair has no sibling on this level
+		if index%2 == 1 {
+			path = append(path, ProofStep{level[index-1], true})
+		} else if index+1 < len(level) {
+			path = append(path, ProofStep{level[index+1], false})
+		}
+
+		level = merkleLevel(level)
+		index /= 2
+	}
+
+	return path
+}
+
+func NewInclusionProof(block *Block, txId []byte) (*InclusionProof, error) {
+	if block.Version == BLOCK_VERSION_LEGACY {
+		return nil, LegacyBlockErr
+	}
+
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.Id, txId) {
+			return &InclusionProof{
+				TxId: txId,
+				Path: merkleProof(block.Transactions, i),
+				Block: BlockHeader{
+					Version:       block.Version,
+					Height:        block.Height,
+					Timestamp:     block.Timestamp,
+					PrevBlockHash: block.PrevBlockHash,
+					MerkleRoot:    block.MerkleRoot,
+					BlockHash:     block.BlockHash,
+				},
+			}, nil
+		}
+	}
+
+	return nil, TxNotFoundErr
+}
+
+// VerifyInclusion checks that transaction id from proof together with
+// sibling path gives merkle root. Root is expected to be taken from
+// trusted block header.
+func VerifyInclusion(proof *InclusionProof, root []byte) bool {
+	hash := merkleLeaf(proof.TxId)
+
+	for _, step := range proof.Path {
+		if step.Left {
+			hash = merkleNode(step.Hash, hash)
+		} else {
+			hash = merkleNode(hash, step.Hash)
+		}
+	}
+
+	return bytes.Equal(hash, root)
+}
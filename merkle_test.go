@@ -0,0 +1,58 @@
+package minichain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestVerifyInclusion(t *testing.T) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+
+	for txCount := 1; txCount <= 7; txCount++ {
+		transactions := make([]Transaction, 0, txCount)
+
+		for i := 0; i < txCount; i++ {
+			transactions = append(transactions, *NewTransaction(fmt.Sprintf("key%d", i), []byte("value")))
+		}
+
+		block := NewBlock(0, genesis[:], transactions)
+
+		for _, tx := range transactions {
+			proof, err := NewInclusionProof(block, tx.Id)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !VerifyInclusion(proof, block.MerkleRoot) {
+				t.Errorf("Proof of tx %x in block with %d txs is not valid", tx.Id, txCount)
+			}
+		}
+	}
+}
+
+func TestVerifyInclusionWrongTx(t *testing.T) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	transactions := []Transaction{
+		*NewTransaction("key1", []byte("value1")),
+		*NewTransaction("key2", []byte("value2")),
+	}
+	block := NewBlock(0, genesis[:], transactions)
+
+	proof, err := NewInclusionProof(block, transactions[0].Id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof.TxId = NewTransaction("key3", []byte("value3")).Id
+
+	if VerifyInclusion(proof, block.MerkleRoot) {
+		t.Errorf("Proof of transaction that is not in block is valid")
+	}
+
+	if _, err := NewInclusionProof(block, proof.TxId); err != TxNotFoundErr {
+		t.Errorf("Expected error %v actual %v", TxNotFoundErr, err)
+	}
+}
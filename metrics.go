@@ -0,0 +1,98 @@
+package minichain
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Reasons of transaction rejection
+	REJECT_RATE_LIMIT   = "rate-limit"
+	REJECT_MEMPOOL_FULL = "mempool-full"
+	REJECT_INVALID      = "invalid"
+	REJECT_FORBIDDEN    = "forbidden"
+	REJECT_CONFLICT     = "conflict"
+	REJECT_DUPLICATE    = "duplicate"
+
+	// What made blockchain flush batch to disk
+	FLUSH_BY_SIZE     = "size"
+	FLUSH_BY_TICKER   = "ticker"
+	FLUSH_BY_SHUTDOWN = "shutdown"
+
+	// Searches by time use time index
+	SEARCH_TIME_INDEX = "Time"
+	// Hash, time and state indexes are built together with one pass
+	CHAIN_INDEXES = "Chain"
+)
+
+var (
+	acceptedTransactions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "minichain_transactions_accepted_total",
+		Help: "Transactions added to the batch",
+	})
+	rejectedTransactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "minichain_transactions_rejected_total",
+		Help: "Transactions rejected by reason",
+	}, []string{"reason"})
+	blocksFlushed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "minichain_blocks_flushed_total",
+		Help: "Blocks written to disk by trigger of flush",
+	}, []string{"trigger"})
+	flushSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "minichain_flush_duration_seconds",
+		Help: "Time to build block, write it to disk and update indexes",
+	})
+	fsyncSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "minichain_fsync_duration_seconds",
+		Help: "Time to sync data file after block is written",
+	})
+	blockSizeBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "minichain_block_size_bytes",
+		Help:    "Size of block record in data file",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	})
+	searchSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "minichain_search_duration_seconds",
+		Help: "Time of search by index type",
+	}, []string{"index"})
+	bloomFalsePositives = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "minichain_bloom_filter_false_positives_total",
+		Help: "Blocks read because bloom filter matched key that block doesn't contain",
+	})
+	indexBuildSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "minichain_index_build_seconds",
+		Help: "Time to build or load index at startup by index type",
+	}, []string{"index"})
+	chainHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "minichain_chain_height",
+		Help: "Count of blocks in chain",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		acceptedTransactions,
+		rejectedTransactions,
+		blocksFlushed,
+		flushSeconds,
+		fsyncSeconds,
+		blockSizeBytes,
+		searchSeconds,
+		bloomFalsePositives,
+		indexBuildSeconds,
+		chainHeight,
+	)
+}
+
+// Registers gauge of commit requests waiting in mempool of blockchain
+func RegisterMempoolMetrics(b *BlockChain) error {
+	return prometheus.Register(newMempoolDepth(b))
+}
+
+func newMempoolDepth(b *BlockChain) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "minichain_mempool_depth",
+		Help: "Commit requests waiting for blockchain",
+	}, func() float64 {
+		return float64(b.MempoolDepth())
+	})
+}
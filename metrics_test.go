@@ -0,0 +1,187 @@
+package minichain
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Returns count of observations of histogram from default registry
+func histogramCount(t *testing.T, name string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+
+	t.Fatalf("Metric %s is not registered", name)
+	return 0
+}
+
+func TestMetricsCommit(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 2, true)
+	defer cleanup()
+
+	accepted := testutil.ToFloat64(acceptedTransactions)
+	flushes := histogramCount(t, "minichain_flush_duration_seconds")
+
+	req := &CommitRequest{
+		Transactions: []Transaction{
+			*NewTransaction("key1", []byte("value1")),
+			*NewTransaction("key2", []byte("value2")),
+		},
+		ResultChan: make(chan error, 1),
+	}
+	blockChain.Commit <- req
+
+	if err := <-req.ResultChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := testutil.ToFloat64(acceptedTransactions) - accepted; actual != 2 {
+		t.Errorf("Expected %d accepted transactions actual %v", 2, actual)
+	}
+
+	if actual := histogramCount(t, "minichain_flush_duration_seconds") - flushes; actual != 1 {
+		t.Errorf("Expected %d observed flush actual %d", 1, actual)
+	}
+}
+
+func TestMetricsReject(t *testing.T) {
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   10,
+		ValueMaxSize: 10,
+		Timeout:      time.Second,
+		Auth:         testAuthenticator(t),
+		BlockChain: &BlockChain{
+			Commit: make(chan *CommitRequest),
+		},
+	}
+
+	rejected := func(reason string) float64 {
+		return testutil.ToFloat64(rejectedTransactions.WithLabelValues(reason))
+	}
+
+	testData := []struct {
+		Url      string
+		Body     string
+		Expected map[string]float64
+	}{
+		{
+			Url:      "/tx?key=order-long-key&value=v",
+			Expected: map[string]float64{REJECT_INVALID: 1},
+		},
+		{
+			Url:      "/tx?key=user-1&value=v",
+			Expected: map[string]float64{REJECT_FORBIDDEN: 1},
+		},
+		{
+			// Nobody reads mempool
+			Url:      "/tx?key=order-1&value=v",
+			Expected: map[string]float64{REJECT_MEMPOOL_FULL: 1},
+		},
+		{
+			// Invalid transactions after forbidden one are still invalid
+			Url:      "/tx/batch",
+			Body:     `[{"key":"order-1","value":"dg=="},{"key":"user-1","value":"dg=="},{"key":"order-long-key","value":"dg=="},{"key":"order-2","value":"dg=="}]`,
+			Expected: map[string]float64{REJECT_INVALID: 1, REJECT_FORBIDDEN: 3},
+		},
+	}
+
+	reasons := []string{REJECT_INVALID, REJECT_FORBIDDEN, REJECT_MEMPOOL_FULL}
+
+	for _, test := range testData {
+		before := make(map[string]float64)
+
+		for _, reason := range reasons {
+			before[reason] = rejected(reason)
+		}
+
+		w := httptest.NewRecorder()
+
+		if len(test.Body) == 0 {
+			req := httptest.NewRequest(http.MethodGet, test.Url, nil)
+			req.Header.Set("Authorization", "Bearer orders-token")
+			blockChainServer.TransactionHandler(w, req)
+		} else {
+			req := httptest.NewRequest(http.MethodPost, test.Url, strings.NewReader(test.Body))
+			req.Header.Set("Authorization", "Bearer orders-token")
+			blockChainServer.BatchHandler(w, req)
+		}
+
+		for _, reason := range reasons {
+			if actual := rejected(reason) - before[reason]; actual != test.Expected[reason] {
+				t.Errorf("%s expected %v transactions rejected as %s actual %v", test.Url, test.Expected[reason], reason, actual)
+			}
+		}
+	}
+}
+
+func TestMetricsMempoolDepth(t *testing.T) {
+	blockChain := &BlockChain{
+		Commit: make(chan *CommitRequest, 2),
+	}
+
+	depth := newMempoolDepth(blockChain)
+
+	for i := 0; i < 2; i++ {
+		if actual := testutil.ToFloat64(depth); actual != float64(i) {
+			t.Errorf("Expected mempool depth %d actual %v", i, actual)
+		}
+
+		blockChain.Commit <- &CommitRequest{
+			Transactions: []Transaction{*NewTransaction("key", []byte("value"))},
+		}
+	}
+
+	if actual := testutil.ToFloat64(depth); actual != 2 {
+		t.Errorf("Expected mempool depth %d actual %v", 2, actual)
+	}
+}
+
+func TestMetricsBloomFalsePositives(t *testing.T) {
+	_, data := buildChain(t, 3)
+	index, _, err := NewBloomFilterIndex(bytes.NewReader(data), DEFAULT_FALSE_POSITIVE_RATE)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashIndex, err := NewHashIndex(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Filters of all blocks match key of the first block
+	for _, info := range index.(*BloomFilterIndex).blocks {
+		info.filter.AddString("key0")
+	}
+
+	falsePositives := testutil.ToFloat64(bloomFalsePositives)
+	versions, err := indexVersions("key0", index, hashIndex, bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 1 {
+		t.Errorf("Expected %d versions actual %d", 1, len(versions))
+	}
+
+	if actual := testutil.ToFloat64(bloomFalsePositives) - falsePositives; actual != 2 {
+		t.Errorf("Expected %d false positives actual %v", 2, actual)
+	}
+}
@@ -0,0 +1,90 @@
+package minichain
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Buckets that are refilled are dropped when there are more clients than
+// this, so limiter memory doesn't grow with every client seen
+const RATE_LIMIT_MAX_CLIENTS = 10000
+
+// RateLimiter keeps token bucket of every client. Bucket holds up to burst
+// tokens and is refilled with rate tokens per second, every transaction
+// takes one token.
+type RateLimiter struct {
+	m       sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Takes n tokens from bucket of client, returns false and time after which
+// there will be enough tokens if bucket has less than n. Request larger
+// than burst is never allowed, zero wait is returned for it.
+func (limiter *RateLimiter) Allow(client string, n int) (bool, time.Duration) {
+	limiter.m.Lock()
+	defer limiter.m.Unlock()
+
+	if float64(n) > limiter.burst {
+		return false, 0
+	}
+
+	now := limiter.now()
+	need := float64(n)
+	b, ok := limiter.buckets[client]
+
+	if !ok {
+		if len(limiter.buckets) >= RATE_LIMIT_MAX_CLIENTS {
+			limiter.prune(now)
+		}
+
+		b = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[client] = b
+	}
+
+	b.tokens = math.Min(limiter.burst, b.tokens+now.Sub(b.last).Seconds()*limiter.rate)
+	b.last = now
+
+	if b.tokens < need {
+		wait := (need - b.tokens) / limiter.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	b.tokens -= need
+	return true, 0
+}
+
+// Returns count of transactions that can be allowed at once
+func (limiter *RateLimiter) Burst() int {
+	return int(limiter.burst)
+}
+
+// Drops buckets that are full, such clients are not distinguished from new
+func (limiter *RateLimiter) prune(now time.Time) {
+	for client, b := range limiter.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, client)
+		}
+	}
+}
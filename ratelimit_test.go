@@ -0,0 +1,61 @@
+package minichain
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewRateLimiter(2, 4)
+	limiter.now = func() time.Time { return now }
+
+	if ok, _ := limiter.Allow("a", 4); !ok {
+		t.Errorf("Burst is not allowed")
+	}
+
+	ok, wait := limiter.Allow("a", 1)
+
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("Expected wait %v actual %v allowed %v", 500*time.Millisecond, wait, ok)
+	}
+
+	if ok, _ := limiter.Allow("b", 1); !ok {
+		t.Errorf("Clients share bucket")
+	}
+
+	now = now.Add(time.Second)
+
+	if ok, _ := limiter.Allow("a", 2); !ok {
+		t.Errorf("Bucket has not been refilled")
+	}
+
+	// Request larger than burst is not allowed even with full bucket
+	now = now.Add(time.Hour)
+
+	if ok, wait := limiter.Allow("a", 10); ok || wait != 0 {
+		t.Errorf("Request larger than burst is allowed %v wait %v", ok, wait)
+	}
+
+	if ok, _ := limiter.Allow("a", 4); !ok {
+		t.Errorf("Rejected request larger than burst has taken tokens")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := NewRateLimiter(1, 1)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < RATE_LIMIT_MAX_CLIENTS; i++ {
+		limiter.Allow(fmt.Sprintf("client%d", i), 1)
+	}
+
+	now = now.Add(time.Second)
+	limiter.Allow("new", 1)
+
+	if len(limiter.buckets) != 1 {
+		t.Errorf("Expected %d buckets after prune actual %d", 1, len(limiter.buckets))
+	}
+}
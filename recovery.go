@@ -0,0 +1,160 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+const QUARANTINE_SUFFIX = ".tail"
+
+// Recovers blockchain data file after crash in the middle of flush. File is
+// scanned from the beginning and torn record at the end of file is moved to
+// quarantine file and truncated. Record is considered complete when it can
+// be decoded and digest at the end of record matches block hash. Links
+// between blocks are not checked here, VerifyChain does that. Data file
+// with any other broken record is left untouched and *ChainError is
+// returned.
+// Scan starts from the end of snapshot if it is set, blocks before it have
+// been synced. Every complete block is passed to update function, so indexes
+// are built with the same pass over data file.
+// Returns hash of the last block, count of blocks and size of the data file
+// after recovery.
+func recoverChain(fileName string, from SnapshotHeader, update func(int64, uint64, *Block)) ([]byte, uint64, int64, error) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	lastBlockHash := genesis[:]
+
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0600)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	if err := seekSnapshot(f, from); err != nil {
+		return nil, 0, 0, err
+	}
+
+	var (
+		blockCount = from.Height
+		offset     int64
+		block      *Block
+		digest     []byte
+	)
+
+	if from.Offset != 0 {
+		lastBlockHash = from.LastBlockHash
+	}
+
+	for {
+		block, digest, offset, err = readRecord(f)
+
+		if err == nil && !bytes.Equal(digest, block.BlockHash) {
+			err = DigestMismatchErr
+		}
+
+		if err != nil {
+			break
+		}
+
+		if update != nil {
+			update(offset, blockCount, block)
+		}
+
+		lastBlockHash = block.BlockHash
+		blockCount++
+	}
+
+	if err == io.EOF {
+		GetLogger().Debugf("Data file %s is consistent, %d blocks found", fileName, blockCount)
+		return lastBlockHash, blockCount, offset, nil
+	}
+
+	info, statErr := f.Stat()
+
+	if statErr != nil {
+		return nil, 0, 0, statErr
+	}
+
+	torn, tornErr := isTornTail(f, offset, info.Size(), err)
+
+	if tornErr != nil {
+		return nil, 0, 0, tornErr
+	}
+
+	// Record written by newer release, record followed by other records or
+	// read error must not make valid blocks dropped
+	if !torn {
+		return nil, 0, 0, &ChainError{offset, err}
+	}
+
+	GetLogger().Warnf("Broken record on offset %d in %s: %v, drop %d bytes after %d valid blocks",
+		offset, fileName, err, info.Size()-offset, blockCount)
+
+	if err := quarantine(f, offset, fileName+QUARANTINE_SUFFIX); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := f.Truncate(offset); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return lastBlockHash, blockCount, offset, nil
+}
+
+// Tells whether broken record on offset is the tail left by crash during
+// flush: record is cut short, or it ends at the end of file and can't be
+// decoded or its digest doesn't match block hash.
+func isTornTail(f *os.File, offset, size int64, err error) (bool, error) {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+	default:
+		if err == NotEnoughDataErr {
+			return true, nil
+		}
+
+		if err != DigestMismatchErr {
+			return false, nil
+		}
+	}
+
+	header := make([]byte, HEADER_SIZE)
+
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return false, err
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint32(header))
+
+	return offset+HEADER_SIZE+blockSize+DIGEST_SIZE == size, nil
+}
+
+// Copy data starting from offset to the end of file to quarantine file, so
+// dropped bytes can be inspected later. Bytes of every recovery are appended,
+// so tail quarantined before is not lost.
+func quarantine(f *os.File, offset int64, fileName string) error {
+	if _, err := f.Seek(offset, 0); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, f); err != nil {
+		return err
+	}
+
+	GetLogger().Infof("Dropped bytes have been saved to %s", fileName)
+	return out.Sync()
+}
@@ -0,0 +1,193 @@
+package minichain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoverChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 3)
+	lastRecord := encodeRecord(t, blocks[2])
+	validSize := int64(len(data) - len(lastRecord))
+	torn := lastRecord[:len(lastRecord)-DIGEST_SIZE/2]
+
+	fileName := filepath.Join(dir, "blockchain.dat")
+	tornData := append(data[:validSize], torn...)
+
+	if err := ioutil.WriteFile(fileName, tornData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lastBlockHash, height, offset, err := recoverChain(fileName, SnapshotHeader{}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if height != 2 {
+		t.Errorf("Expected height %d actual %d", 2, height)
+	}
+
+	if offset != validSize {
+		t.Errorf("Expected offset %d actual %d", validSize, offset)
+	}
+
+	if !bytes.Equal(lastBlockHash, blocks[1].BlockHash) {
+		t.Errorf("Expected last block hash %v actual %v", blocks[1].BlockHash, lastBlockHash)
+	}
+
+	info, err := os.Stat(fileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != validSize {
+		t.Errorf("Expected file size %d actual %d", validSize, info.Size())
+	}
+
+	tail, err := ioutil.ReadFile(fileName + QUARANTINE_SUFFIX)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(tail, torn) {
+		t.Errorf("Quarantine file does not contain dropped bytes")
+	}
+
+	// The second recovery keeps bytes quarantined by the first one
+	if err := ioutil.WriteFile(fileName, tornData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := recoverChain(fileName, SnapshotHeader{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if tail, err = ioutil.ReadFile(fileName + QUARANTINE_SUFFIX); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(tail, append(append([]byte{}, torn...), torn...)) {
+		t.Errorf("Quarantine file has been overwritten by the second recovery")
+	}
+}
+
+func TestRecoverChainBrokenRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 3)
+	sizes := []int{
+		len(encodeRecord(t, blocks[0])),
+		len(encodeRecord(t, blocks[1])),
+		len(encodeRecord(t, blocks[2])),
+	}
+
+	testData := []struct {
+		Name string
+		// Byte to flip, digest is the last bytes of record
+		Position     int
+		ExpectedErr  bool
+		ExpectedSize int64
+	}{
+		{"Digest of the last record", len(data) - 1, false, int64(sizes[0] + sizes[1])},
+		{"Digest of record in the middle", sizes[0] + sizes[1] - 1, true, int64(len(data))},
+		{"Block of record in the middle", sizes[0] + HEADER_SIZE, true, int64(len(data))},
+	}
+
+	for _, test := range testData {
+		fileName := filepath.Join(dir, "blockchain.dat")
+		broken := append([]byte{}, data...)
+		broken[test.Position] ^= 0xff
+
+		if err := ioutil.WriteFile(fileName, broken, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, _, _, err := recoverChain(fileName, SnapshotHeader{}, nil)
+
+		if _, ok := err.(*ChainError); ok != test.ExpectedErr {
+			t.Errorf("%s: expected chain error %v actual %v", test.Name, test.ExpectedErr, err)
+		}
+
+		info, err := os.Stat(fileName)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() != test.ExpectedSize {
+			t.Errorf("%s: expected file size %d actual %d", test.Name, test.ExpectedSize, info.Size())
+		}
+	}
+}
+
+func TestRecoverChainEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, _, offset, err := recoverChain(filepath.Join(dir, "blockchain.dat"), SnapshotHeader{}, nil)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if offset != 0 {
+		t.Errorf("Expected offset %d actual %d", 0, offset)
+	}
+}
+
+func TestRecoverChainUnsupportedVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 2)
+	newer := NewBlock(2, blocks[1].BlockHash, []Transaction{*NewTransaction("key", []byte("value"))})
+	newer.Version = BLOCK_VERSION + 1
+	newer.BlockHash = newer.Hash()
+	data = append(data, encodeRecord(t, newer)...)
+
+	fileName := filepath.Join(dir, "blockchain.dat")
+
+	if err := ioutil.WriteFile(fileName, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := recoverChain(fileName, SnapshotHeader{}, nil); err == nil {
+		t.Errorf("Error expected for block of unsupported version")
+	}
+
+	info, err := os.Stat(fileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Size() != int64(len(data)) {
+		t.Errorf("Data file has been truncated to %d bytes", info.Size())
+	}
+}
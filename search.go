@@ -1,14 +1,193 @@
 package minichain
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
+const (
+	DEFAULT_SEARCH_LIMIT = 100
+	MAX_SEARCH_LIMIT     = 1000
+)
+
+// Position of transaction in blockchain, offset of the block and index of
+// transaction in the block. Encoded as "offset:position" in JSON and query.
+type Cursor struct {
+	Offset   int64
+	Position int
+}
+
+func (cursor Cursor) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d", cursor.Offset, cursor.Position)), nil
+}
+
+// Tells whether cursor points to transaction that is earlier in chain
+func (cursor Cursor) before(other Cursor) bool {
+	return cursor.Offset < other.Offset || cursor.Offset == other.Offset && cursor.Position < other.Position
+}
+
+func (cursor *Cursor) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "%d:%d", &cursor.Offset, &cursor.Position)
+
+	if err != nil || cursor.Offset < 0 || cursor.Position < 0 {
+		return fmt.Errorf("wrong cursor %s", text)
+	}
+
+	return nil
+}
+
+// Search by exact key or, if key is empty, by range of keys [Start, End).
+// Search by time range [From, To] can be combined with key.
 type SearchRequest struct {
 	ctx        context.Context
 	Key        string
+	Start      string
+	End        string
+	ByTime     bool
+	From       int64
+	To         int64
+	Cursor     Cursor
+	Limit      int
 	ResultChan chan *SearchResult
 }
 
 type SearchResult struct {
 	Transactions []Transaction `json:"transactions"`
-	Error        string        `json:"error"`
+	// Key to start the next page of range search from
+	Next string `json:"next,omitempty"`
+	// Cursor of the next page of key or time search, for range search it
+	// points into transactions of next key
+	Cursor *Cursor `json:"cursor,omitempty"`
+	Error  string  `json:"error"`
+}
+
+type ProofRequest struct {
+	ctx        context.Context
+	TxId       []byte
+	ResultChan chan *ProofResult
+}
+
+type ProofResult struct {
+	Proof *InclusionProof `json:"proof"`
+	Err   error           `json:"-"`
+	Error string          `json:"error"`
+}
+
+// Request to find transaction by id or block by hash
+type LookupRequest struct {
+	ctx        context.Context
+	Hash       []byte
+	ByTx       bool
+	ResultChan chan *LookupResult
+}
+
+type LookupResult struct {
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Block       *Block       `json:"block,omitempty"`
+	BlockHash   []byte       `json:"block-hash,omitempty"`
+	BlockLocation
+	Err   error  `json:"-"`
+	Error string `json:"error"`
+}
+
+// Request for status of transaction that has been submitted
+type StatusRequest struct {
+	ctx        context.Context
+	TxId       []byte
+	ResultChan chan *StatusResult
+}
+
+type StatusResult struct {
+	Status    string `json:"status"`
+	BlockHash []byte `json:"block-hash,omitempty"`
+	BlockLocation
+	Error string `json:"error"`
+}
+
+// Request for status of the chain, it is answered by blockchain loop itself,
+// so answer also tells that the loop is alive. Result channel is buffered,
+// so loop never waits for client that has gone.
+type ChainStatusRequest struct {
+	ResultChan chan *ChainStatus
+}
+
+type ChainStatus struct {
+	Height        uint64 `json:"height"`
+	LastBlockHash []byte `json:"last-block-hash"`
+	// Size of data file, torn record is truncated on start
+	DataFileSize int64  `json:"data-file-size"`
+	IndexType    string `json:"index-type"`
+	// Transactions of the batch that is not flushed yet
+	PendingTransactions int `json:"pending-transactions"`
+	MempoolDepth        int `json:"mempool-depth"`
+	// Seconds since the last block has been flushed or since start
+	SinceLastFlush float64 `json:"since-last-flush"`
+}
+
+// Request for the latest value of key, value at point of history if At is
+// set or versions of key starting from Cursor if History is set.
+type StateRequest struct {
+	ctx        context.Context
+	Key        string
+	At         *StatePoint
+	History    bool
+	Cursor     int
+	Limit      int
+	ResultChan chan *StateResult
+}
+
+// Value of key after transaction, version is number of the value in key
+// history. Value differs from value of transaction for appends.
+type KeyVersion struct {
+	Version int `json:"version"`
+	Transaction
+	Value     []byte `json:"value"`
+	BlockHash []byte `json:"block-hash"`
+	BlockLocation
+}
+
+type StateResult struct {
+	Version  *KeyVersion  `json:"version,omitempty"`
+	Versions []KeyVersion `json:"versions,omitempty"`
+	// Version to start the next page of history from
+	Next  int    `json:"next,omitempty"`
+	Err   error  `json:"-"`
+	Error string `json:"error"`
+}
+
+// Transactions that are written to the same block if they fit into block
+// size. If ResultChan is set, result of flush is sent to it when block with
+// the last transaction is synced, channel must be buffered. ConflictError is
+// sent right away if compare-and-set transaction does not match.
+type CommitRequest struct {
+	Transactions []Transaction
+	ResultChan   chan error
+}
+
+// Key value pair of batch request
+type KeyValue struct {
+	// Operation of transaction, put if it is empty
+	Op    string `json:"op,omitempty"`
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	// Condition of compare-and-set, expected id is hex encoded
+	Expected     []byte `json:"expected,omitempty"`
+	ExpectedId   string `json:"expected-id,omitempty"`
+	ExpectAbsent bool   `json:"expect-absent,omitempty"`
+	// Signed transaction has timestamp set by client
+	Timestamp int64  `json:"timestamp,omitempty"`
+	PublicKey []byte `json:"public-key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type BatchError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// Receipts are in the order of batch items, item that failed validation
+// has null receipt and error with its index.
+type BatchResult struct {
+	Transactions []*TransactionReceipt `json:"transactions"`
+	Errors       []BatchError          `json:"errors"`
 }
@@ -2,20 +2,92 @@ package minichain
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	JSON_CONTENT_TYPE         = "application/json"
+	NDJSON_CONTENT_TYPE       = "application/x-ndjson"
+	OCTET_STREAM_CONTENT_TYPE = "application/octet-stream"
+	// Bytes of JSON object besides key and value: field names, quotes, spaces
+	MAX_JSON_OVERHEAD = 1024
+	// Count of transactions in one batch request
+	MAX_BATCH_SIZE = 1000
+	// Bytes of batch request body unless one transaction is larger
+	MAX_BATCH_BYTES = 16 << 20
+	// Seconds that client is asked to wait when mempool is full
+	MEMPOOL_RETRY_AFTER = 1
+	// Connection outlives handler timeout by this, so response written at
+	// handler deadline reaches client
+	HTTP_TIMEOUT_MARGIN = 5 * time.Second
+)
+
+var (
+	UnsupportedContentTypeErr = errors.New("content type is not supported")
+	MethodNotAllowedErr       = errors.New("method is not allowed")
+	MempoolFullErr            = errors.New("mempool is full")
+	BatchTooLargeErr          = errors.New("batch has too many transactions or is too large")
+)
+
 type BlockChainServer struct {
 	KeyMaxSize   int
 	ValueMaxSize int
 	Timeout      time.Duration
-	BlockChain   *BlockChain
+	WaitSync     bool
+	// Namespaces of keys that can be written only by allowed signers
+	Signers *SignerPolicy
+	// Authenticates requests, nil if authentication is off
+	Auth *Authenticator
+	// Limits transactions of every client, nil if rate limit is off
+	Limiter    *RateLimiter
+	BlockChain *BlockChain
 }
 
 func NewBlockChainServer(config *Config) (*BlockChainServer, error) {
+	var signers *SignerPolicy
+
+	if len(config.BlockChain.SignersFile) != 0 {
+		policy, err := LoadSignerPolicy(config.BlockChain.SignersFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		signers = policy
+	}
+
+	var auth *Authenticator
+
+	if config.Auth.IsOn {
+		authenticator, err := NewAuthenticator(config.Auth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		auth = authenticator
+	}
+
+	var limiter *RateLimiter
+
+	if config.Http.RateLimit > 0 {
+		limiter = NewRateLimiter(config.Http.RateLimit, config.Http.RateBurst)
+	}
+
 	blockChain, err := NewBlockChain(config)
 
 	if err != nil {
@@ -26,70 +98,1093 @@ func NewBlockChainServer(config *Config) (*BlockChainServer, error) {
 		KeyMaxSize:   config.BlockChain.KeyMaxSize,
 		ValueMaxSize: config.BlockChain.ValueMaxSize,
 		Timeout:      time.Duration(config.Http.Timeout) * time.Second,
+		WaitSync:     config.BlockChain.WaitSync,
+		Signers:      signers,
+		Auth:         auth,
+		Limiter:      limiter,
 		BlockChain:   blockChain,
 	}, nil
 }
 
+// Returns token of request, nil token if authentication is off. Response
+// is written if request is not authenticated.
+func (blockChainServer *BlockChainServer) authenticate(w http.ResponseWriter, r *http.Request) (*Token, bool) {
+	if blockChainServer.Auth == nil {
+		return nil, true
+	}
+
+	token, err := blockChainServer.Auth.Authenticate(r)
+
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return token, true
+}
+
+// Checks rate limit of client for n transactions, client is identified by
+// token or by remote address. Response is written if request is rejected.
+func (blockChainServer *BlockChainServer) limit(w http.ResponseWriter, r *http.Request, token *Token, n int) bool {
+	if blockChainServer.Limiter == nil {
+		return true
+	}
+
+	client := r.RemoteAddr
+
+	if token != nil {
+		client = "token " + token.Name
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+
+	ok, wait := blockChainServer.Limiter.Allow(client, n)
+
+	if !ok {
+		rejectedTransactions.WithLabelValues(REJECT_RATE_LIMIT).Add(float64(n))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "rate limit is exceeded", http.StatusTooManyRequests)
+	}
+
+	return ok
+}
+
+// Wraps handler that doesn't touch keys, any known token is accepted
+func (blockChainServer *BlockChainServer) Authenticated(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := blockChainServer.authenticate(w, r); ok {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Accepts transaction from query parameters of GET request, JSON body of POST
+// request with base64 encoded value or raw value in body of POST request with
+// application/octet-stream content type and key in query. DELETE request
+// with key in query creates tombstone of the key.
 func (blockChainServer *BlockChainServer) TransactionHandler(w http.ResponseWriter, r *http.Request) {
-	key := r.URL.Query().Get("key")
+	token, ok := blockChainServer.authenticate(w, r)
 
-	if len(key) == 0 {
-		http.Error(w, "Key cannot be empty", http.StatusBadRequest)
+	if !ok || !blockChainServer.limit(w, r, token, 1) {
+		return
+	}
+
+	tx, err := blockChainServer.readTransaction(w, r)
+
+	if err == nil {
+		err = token.AllowKey(PERMISSION_WRITE, tx.Key)
 	}
 
-	if len(key) > blockChainServer.KeyMaxSize {
-		http.Error(w, fmt.Sprintf("Key size is too long %d max allowed %d",
-			len(key), blockChainServer.KeyMaxSize),
-			http.StatusBadRequest)
+	// Requests of wrong method or content type are not counted as rejected
+	// transactions
+	switch err {
+	case nil:
+	case UnsupportedContentTypeErr:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return
+	case MethodNotAllowedErr:
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	case SignerNotAllowedErr, ForbiddenErr:
+		rejectedTransactions.WithLabelValues(REJECT_FORBIDDEN).Inc()
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	default:
+		rejectedTransactions.WithLabelValues(REJECT_INVALID).Inc()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wait, err := blockChainServer.wait(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Client learns whether condition matched only by waiting for the result
+	wait = wait || tx.Type() == OP_CAS
+
+	GetLogger().Infof("Create new transaction key %s value size %d", tx.Key, len(tx.Value))
+
+	receipt := &TransactionReceipt{
+		Id:        hex.EncodeToString(tx.Id),
+		Timestamp: tx.Timestamp,
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
+	defer cancel()
+
+	// Transactions go through the same mempool as batches, so they keep order
+	req := &CommitRequest{
+		Transactions: []Transaction{*tx},
 	}
 
-	value := r.URL.Query().Get("value")
+	if wait {
+		req.ResultChan = make(chan error, 1)
+	}
 
-	if len(value) > blockChainServer.ValueMaxSize {
-		http.Error(w, fmt.Sprintf("Value size is too long %d max allowed %d",
-			len(value), blockChainServer.ValueMaxSize),
-			http.StatusBadRequest)
+	if err := blockChainServer.commit(ctx, req); err != nil {
+		commitError(w, err)
 		return
 	}
 
-	GetLogger().Infof("Create new transaction key %s value %s", key, value)
+	w.Header().Set("Content-Type", "application/json")
 
-	tx := NewTransaction(key, value)
-	blockChainServer.BlockChain.Input <- tx
+	if wait {
+		json.NewEncoder(w).Encode(receipt)
+		return
+	}
 
 	// Status is accepted since transaction flushes to disk asynchronously
 	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(receipt)
 }
 
-func (blockChainServer *BlockChainServer) SearchByKey(w http.ResponseWriter, r *http.Request) {
-	key := r.URL.Query().Get("key")
+// Accepts JSON array or NDJSON stream of key value pairs. Each pair is
+// validated separately, valid ones are sent to blockchain together, so
+// they are written to the same block if batch fits into block size.
+func (blockChainServer *BlockChainServer) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	if len(key) == 0 {
-		http.Error(w, "Key cannot be empty", http.StatusBadRequest)
+	token, ok := blockChainServer.authenticate(w, r)
+
+	if !ok {
+		return
+	}
+
+	wait, err := blockChainServer.wait(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxItems := MAX_BATCH_SIZE
+
+	// Batch larger than burst is never allowed, so it is not read further
+	if blockChainServer.Limiter != nil && blockChainServer.Limiter.Burst() < maxItems {
+		maxItems = blockChainServer.Limiter.Burst()
+	}
+
+	// Client that is out of tokens is rejected before body is decoded, the
+	// rest of batch is charged when count of transactions is known
+	if !blockChainServer.limit(w, r, token, 1) {
+		return
+	}
+
+	items, err := blockChainServer.decodeBatch(w, r, maxItems)
+
+	if err == BatchTooLargeErr {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot decode batch %v", err), http.StatusBadRequest)
+		return
 	}
 
-	resultChan := make(chan *SearchResult)
+	if len(items) == 0 {
+		http.Error(w, "Batch cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	if len(items) > 1 && !blockChainServer.limit(w, r, token, len(items)-1) {
+		return
+	}
+
+	batchResult := &BatchResult{
+		Transactions: make([]*TransactionReceipt, len(items)),
+		Errors:       make([]BatchError, 0),
+	}
+	transactions := make([]Transaction, 0, len(items))
+	var forbiddenErr error
+
+	for i, item := range items {
+		tx, err := blockChainServer.transaction(&item)
+
+		if err != nil {
+			rejectedTransactions.WithLabelValues(REJECT_INVALID).Inc()
+			batchResult.Errors = append(batchResult.Errors, BatchError{i, err.Error()})
+			continue
+		}
+
+		if err := token.AllowKey(PERMISSION_WRITE, tx.Key); err != nil && forbiddenErr == nil {
+			forbiddenErr = fmt.Errorf("Transaction %d: %v", i, err)
+		}
+
+		transactions = append(transactions, *tx)
+		wait = wait || tx.Type() == OP_CAS
+		batchResult.Transactions[i] = &TransactionReceipt{
+			Id:        hex.EncodeToString(tx.Id),
+			Timestamp: tx.Timestamp,
+		}
+	}
+
+	// Batch is rejected as a whole, so it doesn't overwrite keys partially,
+	// valid transactions are rejected because of forbidden one
+	if forbiddenErr != nil {
+		rejectedTransactions.WithLabelValues(REJECT_FORBIDDEN).Add(float64(len(transactions)))
+		http.Error(w, forbiddenErr.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if len(transactions) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(batchResult)
+		return
+	}
+
+	GetLogger().Infof("Create batch of %d transactions", len(transactions))
+
 	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
 	defer cancel()
 
+	req := &CommitRequest{
+		Transactions: transactions,
+	}
+
+	if wait {
+		req.ResultChan = make(chan error, 1)
+	}
+
+	if err := blockChainServer.commit(ctx, req); err != nil {
+		commitError(w, err)
+		return
+	}
+
+	if wait {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+
+	json.NewEncoder(w).Encode(batchResult)
+}
+
+// Reads and validates transaction
+func (blockChainServer *BlockChainServer) readTransaction(w http.ResponseWriter, r *http.Request) (*Transaction, error) {
+	item, err := blockChainServer.readKeyValue(w, r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return blockChainServer.transaction(item)
+}
+
+// Validates key value pair and creates transaction from it, signature of
+// transaction is checked and its signer is checked against signer policy.
+func (blockChainServer *BlockChainServer) transaction(item *KeyValue) (*Transaction, error) {
+	if err := blockChainServer.validate(item); err != nil {
+		return nil, err
+	}
+
+	tx := newTransaction(item)
+
+	if err := tx.Verify(); err != nil {
+		return nil, err
+	}
+
+	if blockChainServer.Signers != nil {
+		if err := blockChainServer.Signers.Allow(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// Creates transaction from validated key value pair, signed transaction
+// keeps timestamp set by client since it is covered by signature, validate
+// allows timestamp only for signed transactions.
+func newTransaction(item *KeyValue) *Transaction {
+	var tx *Transaction
+
+	if item.Op == OP_CAS {
+		expectedId, _ := hex.DecodeString(item.ExpectedId)
+		tx = NewCompareAndSetTransaction(item.Key, item.Value, item.Expected, expectedId)
+		tx.ExpectAbsent = item.ExpectAbsent
+	} else {
+		tx = NewTypedTransaction(item.Op, item.Key, item.Value)
+	}
+
+	if item.Timestamp != 0 {
+		tx.Timestamp = item.Timestamp
+	}
+
+	tx.PublicKey = item.PublicKey
+	tx.Signature = item.Signature
+	tx.Id = tx.Hash()
+
+	return tx
+}
+
+// Reads operation, key and value of transaction depending on request method
+// and content type, body is limited to the size of valid transaction. DELETE
+// request creates tombstone of key.
+func (blockChainServer *BlockChainServer) readKeyValue(w http.ResponseWriter, r *http.Request) (*KeyValue, error) {
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		return queryKeyValue(query)
+	case http.MethodDelete:
+		item, err := queryKeyValue(query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		item.Op = OP_DELETE
+		return item, nil
+	case http.MethodPost:
+	default:
+		return nil, MethodNotAllowedErr
+	}
+
+	switch r.Header.Get("Content-Type") {
+	case OCTET_STREAM_CONTENT_TYPE:
+		item, err := queryKeyValue(query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Read one byte more than allowed to find out that value is too long
+		item.Value, err = ioutil.ReadAll(io.LimitReader(r.Body, int64(blockChainServer.ValueMaxSize)+1))
+
+		return item, err
+	case JSON_CONTENT_TYPE:
+		item := &KeyValue{}
+		err := json.NewDecoder(io.LimitReader(r.Body, blockChainServer.maxItemSize())).Decode(item)
+
+		return item, err
+	default:
+		return nil, UnsupportedContentTypeErr
+	}
+}
+
+// Returns size of the largest valid JSON key value pair. Key may be escaped
+// in JSON and value is base64 encoded, expected value of compare-and-set is
+// limited as value.
+func (blockChainServer *BlockChainServer) maxItemSize() int64 {
+	return int64(6*blockChainServer.KeyMaxSize +
+		2*base64.StdEncoding.EncodedLen(blockChainServer.ValueMaxSize) + MAX_JSON_OVERHEAD)
+}
+
+// Reads key value pair from query parameters, public key and signature are
+// hex encoded
+func queryKeyValue(query url.Values) (*KeyValue, error) {
+	item := &KeyValue{
+		Op:         query.Get("op"),
+		Key:        query.Get("key"),
+		Value:      []byte(query.Get("value")),
+		Expected:   []byte(query.Get("expected")),
+		ExpectedId: query.Get("expected-id"),
+	}
+
+	var err error
+
+	if expectAbsent := query.Get("expect-absent"); len(expectAbsent) != 0 {
+		if item.ExpectAbsent, err = strconv.ParseBool(expectAbsent); err != nil {
+			return nil, fmt.Errorf("Wrong expect-absent %s", expectAbsent)
+		}
+	}
+
+	if timestamp := query.Get("timestamp"); len(timestamp) != 0 {
+		if item.Timestamp, err = strconv.ParseInt(timestamp, 10, 64); err != nil {
+			return nil, fmt.Errorf("Wrong timestamp %s", timestamp)
+		}
+	}
+
+	if item.PublicKey, err = hex.DecodeString(query.Get("public-key")); err != nil {
+		return nil, errors.New("Public key must be hex encoded")
+	}
+
+	if item.Signature, err = hex.DecodeString(query.Get("signature")); err != nil {
+		return nil, errors.New("Signature must be hex encoded")
+	}
+
+	return item, nil
+}
+
+// Checks transaction key and value against size limits and rules of its
+// operation
+func (blockChainServer *BlockChainServer) validate(item *KeyValue) error {
+	if len(item.Key) == 0 {
+		return errors.New("Key cannot be empty")
+	}
+
+	if len(item.Key) > blockChainServer.KeyMaxSize {
+		return fmt.Errorf("Key size is too long %d max allowed %d",
+			len(item.Key), blockChainServer.KeyMaxSize)
+	}
+
+	if len(item.Value) > blockChainServer.ValueMaxSize {
+		return fmt.Errorf("Value size is too long %d max allowed %d",
+			len(item.Value), blockChainServer.ValueMaxSize)
+	}
+
+	if item.Timestamp != 0 {
+		if len(item.Signature) == 0 {
+			return errors.New("Timestamp can be set only for signed transaction")
+		}
+
+		if skew := item.Timestamp - time.Now().Unix(); skew > MAX_TIMESTAMP_SKEW || skew < -MAX_TIMESTAMP_SKEW {
+			return fmt.Errorf("Timestamp %d differs from server time by more than %d seconds",
+				item.Timestamp, MAX_TIMESTAMP_SKEW)
+		}
+	}
+
+	if item.Op != OP_CAS && (len(item.Expected) != 0 || len(item.ExpectedId) != 0 || item.ExpectAbsent) {
+		return fmt.Errorf("Expected value is allowed only for %s operation", OP_CAS)
+	}
+
+	if item.ExpectAbsent && (len(item.Expected) != 0 || len(item.ExpectedId) != 0) {
+		return errors.New("Absent key can't have expected value")
+	}
+
+	switch item.Op {
+	case "", OP_PUT:
+	case OP_CAS:
+		if len(item.Expected) > blockChainServer.ValueMaxSize {
+			return fmt.Errorf("Expected value size is too long %d max allowed %d",
+				len(item.Expected), blockChainServer.ValueMaxSize)
+		}
+
+		if expectedId, err := hex.DecodeString(item.ExpectedId); err != nil ||
+			(len(expectedId) != 0 && len(expectedId) != sha256.Size) {
+			return errors.New("Expected transaction id must be hex encoded hash")
+		}
+	case OP_APPEND:
+		if len(item.Value) == 0 {
+			return errors.New("Value to append cannot be empty")
+		}
+	case OP_DELETE:
+		if len(item.Value) != 0 {
+			return errors.New("Delete cannot have value")
+		}
+	default:
+		return fmt.Errorf("Unknown operation %s", item.Op)
+	}
+
+	return nil
+}
+
+// Returns whether client waits for transactions to be synced to disk
+func (blockChainServer *BlockChainServer) wait(r *http.Request) (bool, error) {
+	waitStr := r.URL.Query().Get("wait")
+
+	if len(waitStr) == 0 {
+		return blockChainServer.WaitSync, nil
+	}
+
+	wait, err := strconv.ParseBool(waitStr)
+
+	if err != nil {
+		return false, fmt.Errorf("Wrong wait value %s", waitStr)
+	}
+
+	return wait, nil
+}
+
+// Sends transactions to blockchain, if request has result channel waits
+// until block with transactions is synced to disk.
+func (blockChainServer *BlockChainServer) commit(ctx context.Context, req *CommitRequest) error {
+	// Mempool is bounded, client retries instead of blocking handler
+	if err := blockChainServer.BlockChain.Queue(req); err == MempoolFullErr {
+		rejectedTransactions.WithLabelValues(REJECT_MEMPOOL_FULL).Add(float64(len(req.Transactions)))
+		return err
+	} else if err == DuplicateTransactionErr {
+		rejectedTransactions.WithLabelValues(REJECT_DUPLICATE).Add(float64(len(req.Transactions)))
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if req.ResultChan == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		// Transactions are in the batch, but client can't be sure they are on disk
+		return ctx.Err()
+	case err := <-req.ResultChan:
+		return err
+	}
+}
+
+func commitError(w http.ResponseWriter, err error) {
+	if err == MempoolFullErr {
+		w.Header().Set("Retry-After", strconv.Itoa(MEMPOOL_RETRY_AFTER))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	} else if _, ok := err.(*ConflictError); ok || err == DuplicateTransactionErr {
+		http.Error(w, err.Error(), http.StatusConflict)
+	} else if err == context.DeadlineExceeded {
+		http.Error(w, "transactions are not synced in time", http.StatusGatewayTimeout)
+	} else if err == context.Canceled {
+		// Client has gone, nobody reads the response
+		GetLogger().Debug("Client has cancelled request")
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Decodes batch of at most maxItems items, body is limited to the size of
+// the largest valid batch but not more than MAX_BATCH_BYTES.
+func (blockChainServer *BlockChainServer) decodeBatch(w http.ResponseWriter, r *http.Request, maxItems int) ([]KeyValue, error) {
+	maxItemSize := blockChainServer.maxItemSize()
+	maxSize := int64(maxItems) * maxItemSize
+
+	if maxSize > MAX_BATCH_BYTES {
+		maxSize = MAX_BATCH_BYTES
+	}
+
+	// Batch of one transaction of the largest size is always read
+	if maxSize < maxItemSize {
+		maxSize = maxItemSize
+	}
+	body := &limitedReader{
+		reader: http.MaxBytesReader(w, r.Body, maxSize+1),
+		left:   maxSize,
+	}
+	items := make([]KeyValue, 0)
+	decoder := json.NewDecoder(body)
+
+	if r.Header.Get("Content-Type") != NDJSON_CONTENT_TYPE {
+		if err := decoder.Decode(&items); err != nil {
+			return nil, err
+		}
+
+		if len(items) > maxItems {
+			return nil, BatchTooLargeErr
+		}
+
+		return items, nil
+	}
+
+	for {
+		var item KeyValue
+
+		if err := decoder.Decode(&item); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(items) == maxItems {
+			return nil, BatchTooLargeErr
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Reader that fails with BatchTooLargeErr when more than left bytes are
+// read, error of http.MaxBytesReader can't be told from others.
+type limitedReader struct {
+	reader io.Reader
+	left   int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.left -= int64(n)
+
+	if l.left < 0 {
+		return n, BatchTooLargeErr
+	}
+
+	return n, err
+}
+
+// Searches by exact key /search?key=<key>, by prefix /search?prefix=<prefix>
+// or by range of keys /search?start=<start>&end=<end>. Range searches are
+// paginated with limit, next page starts from key returned in next field.
+// Transactions created in time range /search?from=<unix>&to=<unix> can be
+// filtered by key as well, limit restricts count of transactions.
+func (blockChainServer *BlockChainServer) SearchByKey(w http.ResponseWriter, r *http.Request) {
+	token, ok := blockChainServer.authenticate(w, r)
+
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	key := query.Get("key")
+	prefix := query.Get("prefix")
+	start := query.Get("start")
+	end := query.Get("end")
+	fromStr := query.Get("from")
+	toStr := query.Get("to")
+	byTime := len(fromStr) != 0 || len(toStr) != 0
+
+	if len(key) == 0 && len(prefix) == 0 && len(start) == 0 && len(end) == 0 && !byTime {
+		http.Error(w, "Key, prefix, key range or time range must be set", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		from int64
+		to   int64 = math.MaxInt64
+		err  error
+	)
+
+	if len(fromStr) != 0 {
+		if from, err = strconv.ParseInt(fromStr, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Wrong from timestamp %s", fromStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(toStr) != 0 {
+		if to, err = strconv.ParseInt(toStr, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Wrong to timestamp %s", toStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Prefix is a range, start can move it to the next page
+	if len(prefix) != 0 {
+		if start < prefix {
+			start = prefix
+		}
+
+		end = prefixEnd(prefix)
+	}
+
+	if len(key) != 0 {
+		err = token.AllowKey(PERMISSION_READ, key)
+	} else {
+		err = token.AllowRange(PERMISSION_READ, start, end)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	limit, err := parseLimit(query)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cursor Cursor
+
+	if cursorStr := query.Get("cursor"); len(cursorStr) != 0 {
+		if err := cursor.UnmarshalText([]byte(cursorStr)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	req := &SearchRequest{
+		Key:    key,
+		Start:  start,
+		End:    end,
+		ByTime: byTime,
+		From:   from,
+		To:     to,
+		Cursor: cursor,
+		Limit:  limit,
+	}
+
+	if query.Get("stream") == "true" || r.Header.Get("Accept") == NDJSON_CONTENT_TYPE {
+		blockChainServer.stream(w, r, req)
+		return
+	}
+
+	searchResult, err := blockChainServer.search(r.Context(), req)
+
+	if err != nil {
+		// Lets consider timeout for search as a timeout of requesting another service
+		http.Error(w, "search request timed out", http.StatusGatewayTimeout)
+		return
+	}
+
+	if len(searchResult.Transactions) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	json.NewEncoder(w).Encode(searchResult)
+}
+
+// Returns limit query parameter, default limit if it is not set
+func parseLimit(query url.Values) (int, error) {
+	limitStr := query.Get("limit")
+
+	if len(limitStr) == 0 {
+		return DEFAULT_SEARCH_LIMIT, nil
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+
+	if err != nil || limit <= 0 || limit > MAX_SEARCH_LIMIT {
+		return 0, fmt.Errorf("Limit must be in range 1..%d", MAX_SEARCH_LIMIT)
+	}
+
+	return limit, nil
+}
+
+// Writes search results as NDJSON stream one transaction per line. Pages of
+// limit transactions are requested one by one, so neither server nor client
+// keeps the whole result in memory.
+func (blockChainServer *BlockChainServer) stream(w http.ResponseWriter, r *http.Request, req *SearchRequest) {
+	w.Header().Set("Content-Type", NDJSON_CONTENT_TYPE)
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	written := false
+
+	for {
+		searchResult, err := blockChainServer.search(r.Context(), req)
+
+		if err != nil {
+			// Status can't be changed after the first line is written
+			if !written {
+				http.Error(w, "search request timed out", http.StatusGatewayTimeout)
+			}
+
+			return
+		}
+
+		for _, tx := range searchResult.Transactions {
+			if err := encoder.Encode(tx); err != nil {
+				GetLogger().Error(err)
+				return
+			}
+
+			written = true
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if searchResult.Cursor == nil {
+			return
+		}
+
+		if len(searchResult.Next) != 0 {
+			req.Start = searchResult.Next
+		}
+
+		req.Cursor = *searchResult.Cursor
+	}
+}
+
+// Sends search request for one page to blockchain and waits for result
+func (blockChainServer *BlockChainServer) search(ctx context.Context, req *SearchRequest) (*SearchResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, blockChainServer.Timeout)
+	defer cancel()
+
+	req.ctx = ctx
+	req.ResultChan = make(chan *SearchResult)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case blockChainServer.BlockChain.Search <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case searchResult := <-req.ResultChan:
+		return searchResult, nil
+	}
+}
+
+func (blockChainServer *BlockChainServer) ProofHandler(w http.ResponseWriter, r *http.Request) {
+	// Proof contains only hashes, so it doesn't depend on key of transaction
+	if !blockChainServer.authorize(w, r, PERMISSION_READ) {
+		return
+	}
+
+	txId, err := hex.DecodeString(r.URL.Query().Get("tx"))
+
+	if err != nil || len(txId) == 0 {
+		http.Error(w, "Transaction id must be hex encoded hash", http.StatusBadRequest)
+		return
+	}
+
+	resultChan := make(chan *ProofResult)
+	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
+	defer cancel()
+
+	req := &ProofRequest{
 		ctx,
-		key,
+		txId,
 		resultChan,
 	}
 
-	blockChainServer.BlockChain.Search <- req
+	select {
+	case <-ctx.Done():
+		http.Error(w, "proof request timed out", http.StatusGatewayTimeout)
+		return
+	case blockChainServer.BlockChain.Proof <- req:
+	}
 
 	select {
 	case <-ctx.Done():
-		// Lets consider timeout for search as a timeout of requesting another service
-		http.Error(w, "search request timed out", http.StatusGatewayTimeout)
-	case searchResult := <-resultChan:
-		if len(searchResult.Transactions) == 0 {
+		http.Error(w, "proof request timed out", http.StatusGatewayTimeout)
+	case proofResult := <-resultChan:
+		switch proofResult.Err {
+		case nil:
+		case TxNotFoundErr:
 			w.WriteHeader(http.StatusNotFound)
+		case LegacyBlockErr:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
 		}
 
-		json.NewEncoder(w).Encode(searchResult)
+		json.NewEncoder(w).Encode(proofResult)
 	}
 }
+
+// Returns transaction by hex encoded id /tx/{id} or its status /tx/{id}/status
+func (blockChainServer *BlockChainServer) TransactionByIdHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/tx/")
+
+	if strings.HasSuffix(path, "/status") {
+		if blockChainServer.authorize(w, r, PERMISSION_READ) {
+			blockChainServer.status(w, r, strings.TrimSuffix(path, "/status"))
+		}
+
+		return
+	}
+
+	if token, ok := blockChainServer.authenticate(w, r); ok {
+		blockChainServer.lookup(w, r, token, path, true)
+	}
+}
+
+// Returns block by hex encoded hash /block/{hash}
+func (blockChainServer *BlockChainServer) BlockHandler(w http.ResponseWriter, r *http.Request) {
+	if token, ok := blockChainServer.authenticate(w, r); ok {
+		blockChainServer.lookup(w, r, token, strings.TrimPrefix(r.URL.Path, "/block/"), false)
+	}
+}
+
+// Authenticates request and checks that token has permission, response is
+// written if request is denied
+func (blockChainServer *BlockChainServer) authorize(w http.ResponseWriter, r *http.Request, permission string) bool {
+	token, ok := blockChainServer.authenticate(w, r)
+
+	if !ok {
+		return false
+	}
+
+	if err := token.Allow(permission); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// Returns transaction or block if token can read all its keys
+func (blockChainServer *BlockChainServer) lookup(w http.ResponseWriter, r *http.Request, token *Token, hexHash string, byTx bool) {
+	hash, err := hex.DecodeString(hexHash)
+
+	if err != nil || len(hash) == 0 {
+		http.Error(w, "Hash must be hex encoded", http.StatusBadRequest)
+		return
+	}
+
+	resultChan := make(chan *LookupResult)
+	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
+	defer cancel()
+
+	req := &LookupRequest{
+		ctx,
+		hash,
+		byTx,
+		resultChan,
+	}
+
+	select {
+	case <-ctx.Done():
+		http.Error(w, "lookup request timed out", http.StatusGatewayTimeout)
+		return
+	case blockChainServer.BlockChain.Lookup <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		http.Error(w, "lookup request timed out", http.StatusGatewayTimeout)
+	case lookupResult := <-resultChan:
+		switch lookupResult.Err {
+		case nil:
+			if err := lookupResult.allow(token); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		case TxNotFoundErr, BlockNotFoundErr:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		json.NewEncoder(w).Encode(lookupResult)
+	}
+}
+
+func (blockChainServer *BlockChainServer) status(w http.ResponseWriter, r *http.Request, hexId string) {
+	txId, err := hex.DecodeString(hexId)
+
+	if err != nil || len(txId) == 0 {
+		http.Error(w, "Transaction id must be hex encoded hash", http.StatusBadRequest)
+		return
+	}
+
+	resultChan := make(chan *StatusResult)
+	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
+	defer cancel()
+
+	req := &StatusRequest{
+		ctx,
+		txId,
+		resultChan,
+	}
+
+	select {
+	case <-ctx.Done():
+		http.Error(w, "status request timed out", http.StatusGatewayTimeout)
+		return
+	case blockChainServer.BlockChain.Status <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		http.Error(w, "status request timed out", http.StatusGatewayTimeout)
+	case statusResult := <-resultChan:
+		if len(statusResult.Error) != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else if statusResult.Status == TX_UNKNOWN {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		json.NewEncoder(w).Encode(statusResult)
+	}
+}
+
+// Returns the latest value of key /kv/{key}, value at block height or time
+// with at parameter and versions of key /kv/{key}/history
+func (blockChainServer *BlockChainServer) KeyValueHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := blockChainServer.authenticate(w, r)
+
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+	req := &StateRequest{}
+
+	if strings.HasSuffix(key, "/history") {
+		key = strings.TrimSuffix(key, "/history")
+		req.History = true
+	}
+
+	if len(key) == 0 {
+		http.Error(w, "Key must be set", http.StatusBadRequest)
+		return
+	}
+
+	if err := token.AllowKey(PERMISSION_READ, key); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	req.Key = key
+
+	if atStr := query.Get("at"); len(atStr) != 0 {
+		point, err := parseStatePoint(atStr)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req.At = point
+	}
+
+	if req.History {
+		limit, err := parseLimit(query)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req.Limit = limit
+
+		if cursorStr := query.Get("cursor"); len(cursorStr) != 0 {
+			if req.Cursor, err = strconv.Atoi(cursorStr); err != nil || req.Cursor < 0 {
+				http.Error(w, fmt.Sprintf("Wrong cursor %s", cursorStr), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	resultChan := make(chan *StateResult)
+	ctx, cancel := context.WithTimeout(r.Context(), blockChainServer.Timeout)
+	defer cancel()
+
+	req.ctx = ctx
+	req.ResultChan = resultChan
+
+	select {
+	case <-ctx.Done():
+		http.Error(w, "state request timed out", http.StatusGatewayTimeout)
+		return
+	case blockChainServer.BlockChain.State <- req:
+	}
+
+	select {
+	case <-ctx.Done():
+		http.Error(w, "state request timed out", http.StatusGatewayTimeout)
+	case stateResult := <-resultChan:
+		switch stateResult.Err {
+		case nil:
+		case KeyNotFoundErr, KeyDeletedErr:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		json.NewEncoder(w).Encode(stateResult)
+	}
+}
+
+// Point of history is block height or time in RFC3339 format
+func parseStatePoint(at string) (*StatePoint, error) {
+	if height, err := strconv.ParseUint(at, 10, 64); err == nil {
+		return &StatePoint{Height: height}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, at)
+
+	if err != nil {
+		return nil, fmt.Errorf("Wrong point of history %s, height or RFC3339 time expected", at)
+	}
+
+	return &StatePoint{
+		ByTime:    true,
+		Timestamp: t.Unix(),
+	}, nil
+}
@@ -1,15 +1,22 @@
 package minichain
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestBlockChainServerTransactionHandler(t *testing.T) {
 	testData := []struct {
+		Op           string
 		Key          string
 		Value        string
 		ExpectedCode int
@@ -29,28 +36,71 @@ func TestBlockChainServerTransactionHandler(t *testing.T) {
 			Value:        "toolongvalue",
 			ExpectedCode: http.StatusBadRequest,
 		},
+		{
+			Op:           OP_APPEND,
+			Key:          "hello",
+			Value:        "world",
+			ExpectedCode: http.StatusAccepted,
+		},
+		{
+			Op:           OP_APPEND,
+			Key:          "hello",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Op:           OP_DELETE,
+			Key:          "hello",
+			Value:        "world",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Op:           "rename",
+			Key:          "hello",
+			Value:        "world",
+			ExpectedCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, test := range testData {
 		blockChain := &BlockChain{
-			Input: make(chan *Transaction, 1),
+			Commit: make(chan *CommitRequest, 1),
 		}
 
 		blockChainServer := &BlockChainServer{
-			5,
-			5,
-			time.Second,
-			blockChain,
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Second,
+			BlockChain:   blockChain,
 		}
 
 		w := httptest.NewRecorder()
-		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/tx?key=%s&value=%s",
-			test.Key, test.Value), nil)
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/tx?op=%s&key=%s&value=%s",
+			test.Op, test.Key, test.Value), nil)
 		blockChainServer.TransactionHandler(w, req)
 
 		if w.Code != test.ExpectedCode {
 			t.Errorf("Wrong response code expected %d actual %d", test.ExpectedCode, w.Code)
 		}
+
+		if w.Code != http.StatusAccepted {
+			continue
+		}
+
+		receipt := &TransactionReceipt{}
+
+		if err := json.NewDecoder(w.Body).Decode(receipt); err != nil {
+			t.Error(err)
+		}
+
+		tx := (<-blockChain.Commit).Transactions[0]
+
+		if receipt.Id != hex.EncodeToString(tx.Id) || receipt.Timestamp != tx.Timestamp {
+			t.Errorf("Receipt %v does not match transaction %v", receipt, tx)
+		}
+
+		if len(test.Op) != 0 && tx.Type() != test.Op {
+			t.Errorf("Expected operation %s actual %s", test.Op, tx.Type())
+		}
 	}
 }
 
@@ -60,10 +110,10 @@ func TestNewBlockChainServerSearch(t *testing.T) {
 	}
 
 	blockChainServer := &BlockChainServer{
-		5,
-		5,
-		time.Millisecond,
-		blockChain,
+		KeyMaxSize:   5,
+		ValueMaxSize: 5,
+		Timeout:      time.Millisecond,
+		BlockChain:   blockChain,
 	}
 
 	w := httptest.NewRecorder()
@@ -76,3 +126,695 @@ func TestNewBlockChainServerSearch(t *testing.T) {
 			http.StatusGatewayTimeout, w.Code)
 	}
 }
+
+func TestBlockChainServerProof(t *testing.T) {
+	testData := []struct {
+		TxId         string
+		ExpectedCode int
+	}{
+		{
+			TxId:         "not-hex",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			TxId:         "abcd",
+			ExpectedCode: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Proof: make(chan *ProofRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Millisecond,
+			BlockChain:   blockChain,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/proof?tx="+test.TxId, nil)
+
+		blockChainServer.ProofHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("Wrong response code expected %d actual %d", test.ExpectedCode, w.Code)
+		}
+	}
+}
+
+func TestBlockChainServerTransactionWait(t *testing.T) {
+	testData := []struct {
+		FlushErr     error
+		ExpectedCode int
+	}{
+		{
+			FlushErr:     nil,
+			ExpectedCode: http.StatusOK,
+		},
+		{
+			FlushErr:     errors.New("disk is full"),
+			ExpectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Second,
+			BlockChain:   blockChain,
+		}
+
+		go func(flushErr error) {
+			req := <-blockChain.Commit
+			req.ResultChan <- flushErr
+		}(test.FlushErr)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tx?key=hello&value=world&wait=true", nil)
+		blockChainServer.TransactionHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("Wrong response code expected %d actual %d", test.ExpectedCode, w.Code)
+		}
+	}
+}
+
+func TestBlockChainServerBatch(t *testing.T) {
+	testData := []struct {
+		Method         string
+		ContentType    string
+		Body           string
+		ExpectedCode   int
+		ExpectedTxs    int
+		ExpectedErrors int
+	}{
+		{
+			Method:         http.MethodPost,
+			ContentType:    "application/json",
+			Body:           `[{"key": "hello", "value": "d29ybGQ="}, {"key": "toolongkey", "value": "d29ybGQ="}]`,
+			ExpectedCode:   http.StatusAccepted,
+			ExpectedTxs:    1,
+			ExpectedErrors: 1,
+		},
+		{
+			Method:         http.MethodPost,
+			ContentType:    NDJSON_CONTENT_TYPE,
+			Body:           "{\"key\": \"hello\", \"value\": \"d29ybGQ=\"}\n{\"key\": \"apple\", \"value\": \"cGVhcg==\"}\n",
+			ExpectedCode:   http.StatusAccepted,
+			ExpectedTxs:    2,
+			ExpectedErrors: 0,
+		},
+		{
+			Method:         http.MethodPost,
+			ContentType:    "application/json",
+			Body:           `[{"key": "", "value": "d29ybGQ="}]`,
+			ExpectedCode:   http.StatusBadRequest,
+			ExpectedTxs:    0,
+			ExpectedErrors: 1,
+		},
+		{
+			Method:       http.MethodGet,
+			ExpectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			Method:       http.MethodPost,
+			ContentType:  "application/json",
+			Body:         "[" + strings.Repeat(" ", MAX_BATCH_SIZE*MAX_JSON_OVERHEAD*2) + "]",
+			ExpectedCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			Method:       http.MethodPost,
+			ContentType:  NDJSON_CONTENT_TYPE,
+			Body:         strings.Repeat("{\"key\": \"a\", \"value\": \"Yg==\"}\n", MAX_BATCH_SIZE+1),
+			ExpectedCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Second,
+			BlockChain:   blockChain,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.Method, "/tx/batch", strings.NewReader(test.Body))
+		req.Header.Set("Content-Type", test.ContentType)
+		blockChainServer.BatchHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("Wrong response code expected %d actual %d", test.ExpectedCode, w.Code)
+		}
+
+		if test.ExpectedTxs == 0 {
+			continue
+		}
+
+		batchResult := &BatchResult{}
+
+		if err := json.NewDecoder(w.Body).Decode(batchResult); err != nil {
+			t.Error(err)
+		}
+
+		if len(batchResult.Errors) != test.ExpectedErrors {
+			t.Errorf("Wrong error count expected %d actual %d", test.ExpectedErrors, len(batchResult.Errors))
+		}
+
+		commitRequest := <-blockChain.Commit
+
+		if len(commitRequest.Transactions) != test.ExpectedTxs {
+			t.Errorf("Wrong transaction count expected %d actual %d",
+				test.ExpectedTxs, len(commitRequest.Transactions))
+		}
+	}
+}
+
+func TestBlockChainServerTransactionPost(t *testing.T) {
+	testData := []struct {
+		Url          string
+		ContentType  string
+		Body         string
+		ExpectedCode int
+	}{
+		{
+			Url:          "/tx",
+			ContentType:  JSON_CONTENT_TYPE,
+			Body:         `{"key": "hello", "value": "AAEC/w=="}`,
+			ExpectedCode: http.StatusAccepted,
+		},
+		{
+			Url:          "/tx",
+			ContentType:  JSON_CONTENT_TYPE,
+			Body:         `{"key": "hello", "value": "AAECAwQF"}`,
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Url:          "/tx?key=hello",
+			ContentType:  OCTET_STREAM_CONTENT_TYPE,
+			Body:         "\x00\x01\x02\xff",
+			ExpectedCode: http.StatusAccepted,
+		},
+		{
+			Url:          "/tx?key=hello",
+			ContentType:  OCTET_STREAM_CONTENT_TYPE,
+			Body:         "\x00\x01\x02\x03\x04\x05",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Url:          "/tx?key=hello",
+			ContentType:  "text/plain",
+			Body:         "world",
+			ExpectedCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Second,
+			BlockChain:   blockChain,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, test.Url, strings.NewReader(test.Body))
+		req.Header.Set("Content-Type", test.ContentType)
+		blockChainServer.TransactionHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("Wrong response code expected %d actual %d", test.ExpectedCode, w.Code)
+		}
+
+		if w.Code != http.StatusAccepted {
+			continue
+		}
+
+		tx := (<-blockChain.Commit).Transactions[0]
+
+		if !bytes.Equal(tx.Value, []byte{0, 1, 2, 0xff}) {
+			t.Errorf("Wrong transaction value %v", tx.Value)
+		}
+	}
+}
+
+func TestBlockChainServerSearchStream(t *testing.T) {
+	blockChain := &BlockChain{
+		Search: make(chan *SearchRequest),
+	}
+
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   5,
+		ValueMaxSize: 5,
+		Timeout:      time.Second,
+		BlockChain:   blockChain,
+	}
+
+	// Serve two pages of one transaction each
+	go func() {
+		req := <-blockChain.Search
+		req.ResultChan <- &SearchResult{
+			Transactions: []Transaction{*NewTransaction("key", []byte("value1"))},
+			Cursor:       &Cursor{128, 1},
+		}
+
+		req = <-blockChain.Search
+
+		if req.Cursor != (Cursor{128, 1}) {
+			t.Errorf("Wrong cursor of the second page %v", req.Cursor)
+		}
+
+		req.ResultChan <- &SearchResult{
+			Transactions: []Transaction{*NewTransaction("key", []byte("value2"))},
+		}
+	}()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/search?key=key&limit=1&stream=true", nil)
+
+	blockChainServer.SearchByKey(w, req)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+
+	if len(lines) != 2 {
+		t.Errorf("Expected %d lines in stream actual %d", 2, len(lines))
+	}
+}
+
+func TestBlockChainServerTransactionDelete(t *testing.T) {
+	testData := []struct {
+		Method       string
+		Url          string
+		ExpectedCode int
+	}{
+		{
+			Method:       http.MethodDelete,
+			Url:          "/tx?key=hello",
+			ExpectedCode: http.StatusAccepted,
+		},
+		{
+			Method:       http.MethodDelete,
+			Url:          "/tx",
+			ExpectedCode: http.StatusBadRequest,
+		},
+		{
+			Method:       http.MethodPut,
+			Url:          "/tx?key=hello",
+			ExpectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Second,
+			BlockChain:   blockChain,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.Method, test.Url, nil)
+		blockChainServer.TransactionHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("%s %s wrong response code expected %d actual %d", test.Method, test.Url, test.ExpectedCode, w.Code)
+		}
+
+		if w.Code != http.StatusAccepted {
+			continue
+		}
+
+		if tx := (<-blockChain.Commit).Transactions[0]; !tx.IsDelete() || tx.Key != "hello" {
+			t.Errorf("Expected tombstone of key %s actual %v", "hello", tx)
+		}
+	}
+}
+
+func TestBlockChainServerCompareAndSet(t *testing.T) {
+	blockChain := &BlockChain{
+		Commit: make(chan *CommitRequest, 1),
+	}
+
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   5,
+		ValueMaxSize: 5,
+		Timeout:      time.Second,
+		BlockChain:   blockChain,
+	}
+
+	go func() {
+		req := <-blockChain.Commit
+
+		if tx := req.Transactions[0]; tx.Type() != OP_CAS || string(tx.Expected) != "old" {
+			t.Errorf("Wrong compare-and-set transaction %v", tx)
+		}
+
+		req.ResultChan <- &ConflictError{"hello"}
+	}()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/tx?op=cas&key=hello&value=new&expected=old", nil)
+	blockChainServer.TransactionHandler(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Wrong response code expected %d actual %d", http.StatusConflict, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/tx?op=cas&key=hello&value=new&expected-id=abc", nil)
+	blockChainServer.TransactionHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Wrong response code expected %d actual %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBlockChainServerSignedTransaction(t *testing.T) {
+	now := time.Now().Unix()
+	signed := &Transaction{Op: OP_PUT, Key: "order", Value: []byte("v"), Timestamp: now}
+	signed.Sign(testKey(1))
+
+	other := &Transaction{Op: OP_PUT, Key: "order", Value: []byte("v"), Timestamp: now}
+	other.Sign(testKey(2))
+
+	stale := &Transaction{Op: OP_PUT, Key: "order", Value: []byte("v"), Timestamp: now - 2*MAX_TIMESTAMP_SKEW}
+	stale.Sign(testKey(1))
+
+	signedUrl := func(tx *Transaction, value string) string {
+		return fmt.Sprintf("/tx?key=%s&value=%s&timestamp=%d&public-key=%x&signature=%x",
+			tx.Key, value, tx.Timestamp, tx.PublicKey, tx.Signature)
+	}
+
+	policy, err := NewSignerPolicy([]Namespace{{
+		Prefix:  "order",
+		Signers: []string{hex.EncodeToString(signed.PublicKey)},
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		Url          string
+		ExpectedCode int
+	}{
+		{signedUrl(signed, "v"), http.StatusAccepted},
+		{signedUrl(signed, "w"), http.StatusBadRequest},
+		{signedUrl(other, "v"), http.StatusForbidden},
+		{"/tx?key=order&value=v", http.StatusForbidden},
+		{"/tx?key=hello&value=v&signature=xyz", http.StatusBadRequest},
+		{signedUrl(stale, "v"), http.StatusBadRequest},
+		// Timestamp of unsigned transaction is set by server
+		{fmt.Sprintf("/tx?key=hello&value=v&timestamp=%d", now), http.StatusBadRequest},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   5,
+			ValueMaxSize: 5,
+			Timeout:      time.Second,
+			Signers:      policy,
+			BlockChain:   blockChain,
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.Url, nil)
+		blockChainServer.TransactionHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("%s wrong response code expected %d actual %d", test.Url, test.ExpectedCode, w.Code)
+		}
+
+		if w.Code != http.StatusAccepted {
+			continue
+		}
+
+		if tx := (<-blockChain.Commit).Transactions[0]; !bytes.Equal(tx.Id, signed.Id) {
+			t.Errorf("Expected transaction id %x actual %x", signed.Id, tx.Id)
+		}
+	}
+}
+
+func TestBlockChainServerAuth(t *testing.T) {
+	auth := testAuthenticator(t)
+
+	testData := []struct {
+		Method       string
+		Url          string
+		Token        string
+		ExpectedCode int
+	}{
+		{http.MethodGet, "/tx?key=order-1&value=v", "orders-token", http.StatusAccepted},
+		{http.MethodGet, "/tx?key=order-1&value=v", "", http.StatusUnauthorized},
+		{http.MethodGet, "/tx?key=order-1&value=v", "unknown", http.StatusUnauthorized},
+		{http.MethodGet, "/tx?key=user-1&value=v", "orders-token", http.StatusForbidden},
+		{http.MethodGet, "/tx?key=order-1&value=v", "reader-token", http.StatusForbidden},
+		{http.MethodDelete, "/tx?key=user-1", "orders-token", http.StatusForbidden},
+		{http.MethodGet, "/search?key=user-1", "orders-token", http.StatusForbidden},
+		{http.MethodGet, "/search?prefix=user", "orders-token", http.StatusForbidden},
+		{http.MethodGet, "/search?from=1", "orders-token", http.StatusForbidden},
+		{http.MethodGet, "/search?key=order-1", "", http.StatusUnauthorized},
+		{http.MethodGet, "/kv/user-1", "orders-token", http.StatusForbidden},
+		{http.MethodGet, "/proof?tx=abcd", "", http.StatusUnauthorized},
+	}
+
+	for _, test := range testData {
+		blockChain := &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		}
+
+		blockChainServer := &BlockChainServer{
+			KeyMaxSize:   10,
+			ValueMaxSize: 10,
+			Timeout:      time.Second,
+			Auth:         auth,
+			BlockChain:   blockChain,
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/tx", blockChainServer.TransactionHandler)
+		mux.HandleFunc("/search", blockChainServer.SearchByKey)
+		mux.HandleFunc("/kv/", blockChainServer.KeyValueHandler)
+		mux.HandleFunc("/proof", blockChainServer.ProofHandler)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.Method, test.Url, nil)
+
+		if len(test.Token) != 0 {
+			req.Header.Set("Authorization", "Bearer "+test.Token)
+		}
+
+		mux.ServeHTTP(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("%s %s wrong response code expected %d actual %d", test.Method, test.Url, test.ExpectedCode, w.Code)
+		}
+	}
+}
+
+func TestBlockChainServerScopedTimeSearch(t *testing.T) {
+	blockChain, cleanup := newTestBlockChain(t, 10, true)
+	defer cleanup()
+
+	transactions := []Transaction{
+		*NewTransaction("order-1", []byte("value")),
+		*NewTransaction("user-1", []byte("value")),
+	}
+
+	if err := blockChain.flush(transactions); err != nil {
+		t.Fatal(err)
+	}
+
+	blockChainServer := &BlockChainServer{
+		Timeout:    time.Second,
+		Auth:       testAuthenticator(t),
+		BlockChain: blockChain,
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/search?from=0&prefix=order-", nil)
+	req.Header.Set("Authorization", "Bearer orders-token")
+	blockChainServer.SearchByKey(w, req)
+
+	searchResult := &SearchResult{}
+
+	if err := json.NewDecoder(w.Body).Decode(searchResult); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transactions outside of token prefixes are not returned
+	if len(searchResult.Transactions) != 1 || searchResult.Transactions[0].Key != "order-1" {
+		t.Errorf("Wrong transactions of time search with prefix %v", searchResult.Transactions)
+	}
+}
+
+func TestBlockChainServerBackpressure(t *testing.T) {
+	blockChain := &BlockChain{
+		Commit: make(chan *CommitRequest, 1),
+	}
+
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   5,
+		ValueMaxSize: 5,
+		Timeout:      time.Second,
+		Limiter:      NewRateLimiter(1, 2),
+		BlockChain:   blockChain,
+	}
+
+	testData := []struct {
+		ExpectedCode       int
+		ExpectedRetryAfter string
+	}{
+		{http.StatusAccepted, ""},
+		// Mempool is full until blockchain takes request
+		{http.StatusServiceUnavailable, "1"},
+		{http.StatusTooManyRequests, "1"},
+	}
+
+	for i, test := range testData {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tx?key=hello&value=world", nil)
+		blockChainServer.TransactionHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("request %d wrong response code expected %d actual %d", i, test.ExpectedCode, w.Code)
+		}
+
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != test.ExpectedRetryAfter {
+			t.Errorf("request %d expected Retry-After %s actual %s", i, test.ExpectedRetryAfter, retryAfter)
+		}
+	}
+
+	if depth := blockChain.MempoolDepth(); depth != 1 {
+		t.Errorf("Expected mempool depth %d actual %d", 1, depth)
+	}
+}
+
+func TestBlockChainServerBatchBodyLimit(t *testing.T) {
+	// Thousand of the largest transactions would take gigabytes
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   5,
+		ValueMaxSize: 1 << 20,
+		Timeout:      time.Second,
+		BlockChain: &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		},
+	}
+
+	w := httptest.NewRecorder()
+	body := "[" + strings.Repeat(" ", MAX_BATCH_BYTES) + "]"
+	blockChainServer.BatchHandler(w, httptest.NewRequest(http.MethodPost, "/tx/batch", strings.NewReader(body)))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Wrong response code expected %d actual %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
+
+func TestBlockChainServerBatchRateLimit(t *testing.T) {
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   5,
+		ValueMaxSize: 5,
+		Timeout:      time.Second,
+		Limiter:      NewRateLimiter(1, 2),
+		BlockChain: &BlockChain{
+			Commit: make(chan *CommitRequest, 2),
+		},
+	}
+
+	testData := []struct {
+		Body         string
+		ExpectedCode int
+	}{
+		// Batch larger than burst is never allowed
+		{`[{"key":"a","value":"YQ=="},{"key":"b","value":"Yg=="},{"key":"c","value":"Yw=="}]`, http.StatusRequestEntityTooLarge},
+		{`[{"key":"a","value":"YQ=="}]`, http.StatusAccepted},
+		// The last token is taken before body is decoded
+		{`[{"key":"a","value":"YQ=="},{"key":"b","value":"Yg=="}]`, http.StatusTooManyRequests},
+		{`broken`, http.StatusTooManyRequests},
+	}
+
+	for i, test := range testData {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tx/batch", strings.NewReader(test.Body))
+		blockChainServer.BatchHandler(w, req)
+
+		if w.Code != test.ExpectedCode {
+			t.Errorf("request %d wrong response code expected %d actual %d", i, test.ExpectedCode, w.Code)
+		}
+	}
+}
+
+func TestBlockChainServerClientGone(t *testing.T) {
+	// Blockchain never answers, client cancels request while it waits
+	blockChainServer := &BlockChainServer{
+		KeyMaxSize:   5,
+		ValueMaxSize: 5,
+		Timeout:      time.Second,
+		BlockChain: &BlockChain{
+			Commit: make(chan *CommitRequest, 1),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/tx?key=hello&value=world&wait=true", nil)
+	blockChainServer.TransactionHandler(w, req.WithContext(ctx))
+
+	if w.Code == http.StatusInternalServerError {
+		t.Errorf("Cancelled request is reported as server error")
+	}
+}
+
+func TestBlockChainServerLoopNotResponding(t *testing.T) {
+	// Nobody reads channels, like during slow flush or after shutdown
+	blockChainServer := &BlockChainServer{
+		Timeout: 10 * time.Millisecond,
+		BlockChain: &BlockChain{
+			Proof:  make(chan *ProofRequest),
+			Lookup: make(chan *LookupRequest),
+			Status: make(chan *StatusRequest),
+			State:  make(chan *StateRequest),
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/proof", blockChainServer.ProofHandler)
+	mux.HandleFunc("/tx/", blockChainServer.TransactionByIdHandler)
+	mux.HandleFunc("/block/", blockChainServer.BlockHandler)
+	mux.HandleFunc("/kv/", blockChainServer.KeyValueHandler)
+
+	for _, url := range []string{"/proof?tx=abcd", "/tx/abcd", "/tx/abcd/status", "/block/abcd", "/kv/hello"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("%s wrong response code expected %d actual %d", url, http.StatusGatewayTimeout, w.Code)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package minichain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/ed25519"
+	"sort"
+	"strings"
+)
+
+/*
+	Transaction can be signed with Ed25519 key, signature covers canonical
+	encoding of transaction returned by Header and both public key and
+	signature are covered by transaction id, so they can't be stripped from
+	the block.
+
+	Signer policy restricts namespaces of keys to allowed signers. Policy
+	file has following format, public keys are hex encoded.
+
+		[[Namespace]]
+		Prefix = "order-"
+		Signers = ["d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"]
+
+	Key belongs to namespace with the longest matching prefix, keys that
+	don't belong to any namespace can be written unsigned.
+*/
+
+var (
+	SignatureErr        = errors.New("transaction signature is not valid")
+	SignerNotAllowedErr = errors.New("signer is not allowed to write key")
+)
+
+// Signs transaction and updates its id, since id covers signature
+func (tx *Transaction) Sign(privateKey ed25519.PrivateKey) {
+	tx.PublicKey = privateKey.Public().(ed25519.PublicKey)
+	tx.Signature = ed25519.Sign(privateKey, tx.Header())
+	tx.Id = tx.Hash()
+}
+
+// Checks signature of signed transaction, unsigned transaction is valid
+func (tx *Transaction) Verify() error {
+	if len(tx.PublicKey) == 0 && len(tx.Signature) == 0 {
+		return nil
+	}
+
+	if len(tx.PublicKey) != ed25519.PublicKeySize || len(tx.Signature) != ed25519.SignatureSize {
+		return SignatureErr
+	}
+
+	if !ed25519.Verify(tx.PublicKey, tx.Header(), tx.Signature) {
+		return SignatureErr
+	}
+
+	return nil
+}
+
+type Namespace struct {
+	Prefix  string
+	Signers []string
+}
+
+type SignerPolicy struct {
+	Namespace []Namespace
+	// Namespaces sorted by prefix length from the longest
+	namespaces []namespace
+}
+
+type namespace struct {
+	prefix  string
+	signers [][]byte
+}
+
+// Loads signer policy from file
+func LoadSignerPolicy(fileName string) (*SignerPolicy, error) {
+	policy := &SignerPolicy{}
+
+	if _, err := toml.DecodeFile(fileName, policy); err != nil {
+		return nil, err
+	}
+
+	if err := policy.init(); err != nil {
+		return nil, err
+	}
+
+	GetLogger().Infof("Signer policy with %d namespaces has been loaded from %s",
+		len(policy.namespaces), fileName)
+	return policy, nil
+}
+
+func NewSignerPolicy(namespaces []Namespace) (*SignerPolicy, error) {
+	policy := &SignerPolicy{
+		Namespace: namespaces,
+	}
+
+	if err := policy.init(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Decodes signers and sorts namespaces to find the longest prefix first
+func (policy *SignerPolicy) init() error {
+	policy.namespaces = make([]namespace, 0, len(policy.Namespace))
+
+	for _, ns := range policy.Namespace {
+		decoded := namespace{
+			prefix:  ns.Prefix,
+			signers: make([][]byte, 0, len(ns.Signers)),
+		}
+
+		for _, signer := range ns.Signers {
+			publicKey, err := hex.DecodeString(signer)
+
+			if err != nil || len(publicKey) != ed25519.PublicKeySize {
+				return fmt.Errorf("wrong public key %s of namespace %s", signer, ns.Prefix)
+			}
+
+			decoded.signers = append(decoded.signers, publicKey)
+		}
+
+		policy.namespaces = append(policy.namespaces, decoded)
+	}
+
+	sort.SliceStable(policy.namespaces, func(i, j int) bool {
+		return len(policy.namespaces[i].prefix) > len(policy.namespaces[j].prefix)
+	})
+
+	return nil
+}
+
+// Checks that transaction is signed by signer allowed to write its key,
+// signature itself is checked by Verify.
+func (policy *SignerPolicy) Allow(tx *Transaction) error {
+	for _, ns := range policy.namespaces {
+		if !strings.HasPrefix(tx.Key, ns.prefix) {
+			continue
+		}
+
+		for _, signer := range ns.signers {
+			if bytes.Equal(signer, tx.PublicKey) {
+				return nil
+			}
+		}
+
+		return SignerNotAllowedErr
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package minichain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"golang.org/x/crypto/ed25519"
+	"testing"
+)
+
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func TestTransactionSign(t *testing.T) {
+	tx := NewTransaction("key", []byte("value"))
+	tx.Sign(testKey(1))
+
+	if err := tx.Verify(); err != nil {
+		t.Errorf("Signed transaction is not valid %v", err)
+	}
+
+	if !bytes.Equal(tx.Id, tx.Hash()) {
+		t.Errorf("Id of signed transaction does not cover signature")
+	}
+
+	tampered := *tx
+	tampered.Value = []byte("other")
+
+	if err := tampered.Verify(); err != SignatureErr {
+		t.Errorf("Expected error %v actual %v", SignatureErr, err)
+	}
+
+	tampered = *tx
+	tampered.PublicKey = testKey(2).Public().(ed25519.PublicKey)
+
+	if err := tampered.Verify(); err != SignatureErr {
+		t.Errorf("Expected error %v actual %v", SignatureErr, err)
+	}
+
+	if err := NewTransaction("key", []byte("value")).Verify(); err != nil {
+		t.Errorf("Unsigned transaction is not valid %v", err)
+	}
+}
+
+func TestSignerPolicy(t *testing.T) {
+	owner := hex.EncodeToString(testKey(1).Public().(ed25519.PublicKey))
+	admin := hex.EncodeToString(testKey(2).Public().(ed25519.PublicKey))
+
+	policy, err := NewSignerPolicy([]Namespace{
+		{Prefix: "order-", Signers: []string{owner, admin}},
+		{Prefix: "order-admin-", Signers: []string{admin}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		Key         string
+		Signer      byte
+		ExpectedErr error
+	}{
+		{"order-1", 1, nil},
+		{"order-1", 2, nil},
+		{"order-1", 3, SignerNotAllowedErr},
+		{"order-1", 0, SignerNotAllowedErr},
+		{"order-admin-1", 1, SignerNotAllowedErr},
+		{"order-admin-1", 2, nil},
+		{"user-1", 0, nil},
+		{"user-1", 3, nil},
+	}
+
+	for _, test := range testData {
+		tx := NewTransaction(test.Key, []byte("value"))
+
+		if test.Signer != 0 {
+			tx.Sign(testKey(test.Signer))
+		}
+
+		if err := policy.Allow(tx); err != test.ExpectedErr {
+			t.Errorf("Key %s signer %d expected error %v actual %v", test.Key, test.Signer, test.ExpectedErr, err)
+		}
+	}
+
+	if _, err := NewSignerPolicy([]Namespace{{Prefix: "a", Signers: []string{"abc"}}}); err == nil {
+		t.Errorf("Policy with wrong public key has been created")
+	}
+}
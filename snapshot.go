@@ -0,0 +1,238 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+/*
+	Snapshot of index is stored in sidecar file next to data file, so index
+	doesn't need to be rebuilt from the whole data file on start.
+
+	     32 byte          n bytes
+	  +------------+--------------------+
+	  |  checksum  |   gob of snapshot  |
+	  +------------+--------------------+
+
+	Snapshot keeps offset of data file it covers, hash of the last block
+	before that offset and count of blocks, blocks after offset are replayed
+	on start.
+
+	Hash, time and state indexes are built for every index type, they are
+	saved to chain snapshot file <SnapshotFile>.chain with the same layout.
+*/
+
+const CHAIN_SNAPSHOT_SUFFIX = ".chain"
+
+var (
+	SnapshotChecksumErr = errors.New("snapshot checksum mismatch")
+	SnapshotStaleErr    = errors.New("snapshot does not match data file")
+)
+
+type SnapshotHeader struct {
+	Offset        int64
+	LastBlockHash []byte
+	Height        uint64
+}
+
+type chainSnapshot struct {
+	SnapshotHeader
+	Blocks       map[string]BlockLocation
+	Transactions map[string]BlockLocation
+	TimeEntries  []TimeEntry
+	HasState     bool
+	Versions     map[string][]StateLocation
+	HasHeads     bool
+	Heads        map[string]keyHead
+}
+
+// Copies hash, time and state indexes that cover data file up to header
+// offset, so snapshot can be written while indexes are updated. Time
+// entries and versions are only appended, so copy shares them with indexes.
+// State index keeps either all versions or the latest ones.
+func newChainSnapshot(header SnapshotHeader, hashIndex *HashIndex, timeIndex *TimeIndex,
+	stateIndex *StateIndex) *chainSnapshot {
+	hashIndex.m.RLock()
+	defer hashIndex.m.RUnlock()
+	timeIndex.m.RLock()
+	defer timeIndex.m.RUnlock()
+
+	snapshot := &chainSnapshot{
+		SnapshotHeader: header,
+		Blocks:         make(map[string]BlockLocation, len(hashIndex.blocks)),
+		Transactions:   make(map[string]BlockLocation, len(hashIndex.transactions)),
+		TimeEntries:    timeIndex.entries[:len(timeIndex.entries):len(timeIndex.entries)],
+	}
+
+	for hash, location := range hashIndex.blocks {
+		snapshot.Blocks[hash] = location
+	}
+
+	for id, location := range hashIndex.transactions {
+		snapshot.Transactions[id] = location
+	}
+
+	stateIndex.m.RLock()
+	defer stateIndex.m.RUnlock()
+
+	if stateIndex.history {
+		snapshot.HasState = true
+		snapshot.Versions = make(map[string][]StateLocation, len(stateIndex.versions))
+
+		for key, versions := range stateIndex.versions {
+			snapshot.Versions[key] = versions[:len(versions):len(versions)]
+		}
+	} else {
+		snapshot.HasHeads = true
+		snapshot.Heads = make(map[string]keyHead, len(stateIndex.heads))
+
+		for key, head := range stateIndex.heads {
+			snapshot.Heads[key] = head
+		}
+	}
+
+	return snapshot
+}
+
+// Loads hash, time and state indexes from chain snapshot, empty indexes and
+// header are returned when snapshot is missing or broken. Snapshot saved
+// with other history setting of state index is not used.
+func loadChainSnapshot(fileName string, history bool) (*HashIndex, *TimeIndex, *StateIndex, SnapshotHeader) {
+	hashIndex := newHashIndex()
+	timeIndex := NewTimeIndex(TIME_INDEX_STEP)
+	stateIndex := newStateIndex(history)
+
+	if len(fileName) == 0 {
+		return hashIndex, timeIndex, stateIndex, SnapshotHeader{}
+	}
+
+	snapshot := &chainSnapshot{}
+
+	err := readSnapshot(fileName, snapshot)
+
+	if err == nil && (history && !snapshot.HasState || !history && !snapshot.HasHeads) {
+		err = SnapshotStaleErr
+	}
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			GetLogger().Infof("Chain snapshot %s not found", fileName)
+		} else {
+			GetLogger().Warnf("Skip chain snapshot %s: %v", fileName, err)
+		}
+
+		return hashIndex, timeIndex, stateIndex, SnapshotHeader{}
+	}
+
+	GetLogger().Infof("Load chain snapshot %s that covers %d bytes", fileName, snapshot.Offset)
+
+	// Maps of empty index are decoded as nil
+	if snapshot.Blocks != nil {
+		hashIndex.blocks = snapshot.Blocks
+	}
+
+	if snapshot.Transactions != nil {
+		hashIndex.transactions = snapshot.Transactions
+	}
+
+	if snapshot.TimeEntries != nil {
+		timeIndex.entries = snapshot.TimeEntries
+	}
+
+	if history && snapshot.Versions != nil {
+		stateIndex.versions = snapshot.Versions
+	}
+
+	if !history && snapshot.Heads != nil {
+		stateIndex.heads = snapshot.Heads
+	}
+
+	return hashIndex, timeIndex, stateIndex, snapshot.SnapshotHeader
+}
+
+// Writes snapshot to temporary file and renames it, so crash during write
+// never leaves broken snapshot in place of valid one.
+func writeSnapshot(fileName string, snapshot interface{}) error {
+	buf := &bytes.Buffer{}
+
+	if err := gob.NewEncoder(buf).Encode(snapshot); err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+	tmpFileName := fileName + ".tmp"
+	f, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(bytes.Join([][]byte{checksum[:], buf.Bytes()}, []byte{})); err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFileName, fileName)
+}
+
+// Reads snapshot and checks its checksum
+func readSnapshot(fileName string, snapshot interface{}) error {
+	data, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return err
+	}
+
+	if len(data) < DIGEST_SIZE {
+		return NotEnoughDataErr
+	}
+
+	checksum := sha256.Sum256(data[DIGEST_SIZE:])
+
+	if !bytes.Equal(checksum[:], data[:DIGEST_SIZE]) {
+		return SnapshotChecksumErr
+	}
+
+	return gob.NewDecoder(bytes.NewReader(data[DIGEST_SIZE:])).Decode(snapshot)
+}
+
+// Checks that snapshot offset is the end of record with the same block hash
+// and sets reader to that offset to replay the rest of blocks.
+func seekSnapshot(reader io.ReadSeeker, header SnapshotHeader) error {
+	if header.Offset == 0 {
+		_, err := reader.Seek(0, 0)
+		return err
+	}
+
+	if header.Offset < DIGEST_SIZE {
+		return SnapshotStaleErr
+	}
+
+	if _, err := reader.Seek(header.Offset-DIGEST_SIZE, 0); err != nil {
+		return err
+	}
+
+	digest := make([]byte, DIGEST_SIZE)
+
+	if _, err := io.ReadFull(reader, digest); err != nil {
+		return SnapshotStaleErr
+	}
+
+	if !bytes.Equal(digest, header.LastBlockHash) {
+		return SnapshotStaleErr
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package minichain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInvertedIndexSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 4)
+	snapshotSize := int64(len(data) - len(encodeRecord(t, blocks[3])))
+	snapshotFile := filepath.Join(dir, "blockchain.idx")
+
+	index, offset, err := NewInvertedIndex(bytes.NewReader(data[:snapshotSize]))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offset != snapshotSize {
+		t.Errorf("Expected offset %d actual %d", snapshotSize, offset)
+	}
+
+	if err := writeSnapshot(snapshotFile, index.(PersistentIndex).Snapshot(offset, blocks[2].BlockHash)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Block appended after snapshot has to be replayed on load
+	loaded, offset, err := LoadInvertedIndex(bytes.NewReader(data), snapshotFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if offset != int64(len(data)) {
+		t.Errorf("Expected offset %d actual %d", len(data), offset)
+	}
+
+	for _, block := range blocks {
+		key := block.Transactions[0].Key
+		txs, _, err := loaded.Get(key, Cursor{}, DEFAULT_SEARCH_LIMIT)
+
+		if err != nil {
+			t.Errorf("Key %s: %v", key, err)
+			continue
+		}
+
+		if len(txs) != 1 || !bytes.Equal(txs[0].Id, block.Transactions[0].Id) {
+			t.Errorf("Wrong transactions for key %s: %v", key, txs)
+		}
+	}
+
+	txs, next, _, err := loaded.Scan("key", "", Cursor{}, DEFAULT_SEARCH_LIMIT)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(txs) != len(blocks) || next != "" {
+		t.Errorf("Expected %d transactions actual %d next %s", len(blocks), len(txs), next)
+	}
+}
+
+func TestInvertedIndexSnapshotFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 3)
+	snapshotFile := filepath.Join(dir, "blockchain.idx")
+
+	index, offset, err := NewInvertedIndex(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeSnapshot(snapshotFile, index.(PersistentIndex).Snapshot(offset, blocks[2].BlockHash)); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := ioutil.ReadFile(snapshotFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := append([]byte{}, snapshot...)
+	corrupted[len(corrupted)-1] ^= 0xff
+
+	_, otherData := buildChain(t, 2)
+
+	testData := []struct {
+		Name     string
+		Snapshot []byte
+		Data     []byte
+	}{
+		{
+			Name: "missing",
+			Data: data,
+		},
+		{
+			Name:     "corrupted",
+			Snapshot: corrupted,
+			Data:     data,
+		},
+		{
+			// Data file was replaced, snapshot offset points past its end
+			Name:     "stale",
+			Snapshot: snapshot,
+			Data:     otherData,
+		},
+	}
+
+	for _, testCase := range testData {
+		os.Remove(snapshotFile)
+
+		if testCase.Snapshot != nil {
+			if err := ioutil.WriteFile(snapshotFile, testCase.Snapshot, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		loaded, offset, err := LoadInvertedIndex(bytes.NewReader(testCase.Data), snapshotFile)
+
+		if err != nil {
+			t.Errorf("%s: %v", testCase.Name, err)
+			continue
+		}
+
+		if offset != int64(len(testCase.Data)) {
+			t.Errorf("%s: expected offset %d actual %d", testCase.Name, len(testCase.Data), offset)
+		}
+
+		if _, _, err := loaded.Get("key0", Cursor{}, DEFAULT_SEARCH_LIMIT); err != nil {
+			t.Errorf("%s: %v", testCase.Name, err)
+		}
+	}
+}
+
+func TestChainSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blocks, data := buildChain(t, 3)
+	snapshotSize := int64(len(data) - len(encodeRecord(t, blocks[2])))
+	dataFile := filepath.Join(dir, "blockchain.dat")
+	snapshotFile := filepath.Join(dir, "blockchain.idx"+CHAIN_SNAPSHOT_SUFFIX)
+
+	if err := ioutil.WriteFile(dataFile, data[:snapshotSize], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hashIndex, timeIndex, stateIndex, from := loadChainSnapshot(snapshotFile, true)
+	update := func(offset int64, height uint64, block *Block) {
+		hashIndex.Update(offset, height, block)
+		timeIndex.Update(offset, height, block)
+		stateIndex.Update(offset, height, block)
+	}
+	lastBlockHash, height, offset, err := recoverChain(dataFile, from, update)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := SnapshotHeader{offset, lastBlockHash, height}
+
+	if err := writeSnapshot(snapshotFile, newChainSnapshot(header, hashIndex, timeIndex, stateIndex)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only block appended after snapshot is read on start
+	if err := ioutil.WriteFile(dataFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hashIndex, timeIndex, stateIndex, from = loadChainSnapshot(snapshotFile, true)
+	replayed := 0
+	_, height, _, err = recoverChain(dataFile, from, func(offset int64, height uint64, block *Block) {
+		update(offset, height, block)
+		replayed++
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed != 1 || height != 3 {
+		t.Errorf("Expected %d replayed blocks and height %d actual %d and %d", 1, 3, replayed, height)
+	}
+
+	for i, block := range blocks {
+		if location, ok := hashIndex.Block(block.BlockHash); !ok || location.Height != uint64(i) {
+			t.Errorf("Wrong location of block %d %v", i, location)
+		}
+	}
+
+	// Snapshot of another chain is not used
+	from.LastBlockHash = blocks[2].BlockHash
+
+	if _, _, _, err := recoverChain(dataFile, from, nil); err != SnapshotStaleErr {
+		t.Errorf("Expected error %v actual %v", SnapshotStaleErr, err)
+	}
+}
+
+func TestBlockChainSnapshotByTicker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minichain")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		BlockChain: BlockChainConfig{
+			BlockSize:    1,
+			TimeOut:      60,
+			KeyMaxSize:   16,
+			ValueMaxSize: 16,
+			DataFile:     filepath.Join(dir, "blockchain.dat"),
+		},
+		Index: IndexConfig{
+			IndexType:        INVERTED_INDEX,
+			IsOn:             true,
+			SnapshotFile:     filepath.Join(dir, "blockchain.idx"),
+			SnapshotInterval: 1,
+		},
+	}
+
+	blockChain, err := NewBlockChain(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &CommitRequest{
+		Transactions: []Transaction{*NewTransaction("key", []byte("value"))},
+		ResultChan:   make(chan error, 1),
+	}
+	blockChain.Commit <- req
+
+	if err := <-req.ResultChan; err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshot is written while blockchain keeps running
+	chainSnapshotFile := config.Index.SnapshotFile + CHAIN_SNAPSHOT_SUFFIX
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if _, err := os.Stat(chainSnapshotFile); err == nil {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Snapshot %s has not been written", chainSnapshotFile)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	doneChan := make(chan struct{})
+	blockChain.ShutDown <- doneChan
+	<-doneChan
+
+	_, _, _, header := loadChainSnapshot(chainSnapshotFile, false)
+	info, err := os.Stat(config.BlockChain.DataFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Offset != info.Size() || header.Height != 1 {
+		t.Errorf("Snapshot covers %d bytes and %d blocks of %d bytes", header.Offset, header.Height, info.Size())
+	}
+}
@@ -0,0 +1,298 @@
+package minichain
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+/*
+	State index keeps versions of every key in chain order, so current value
+	of the key and its value at some height or time are read with one disk
+	read. Index with all versions is kept in memory when history is on,
+	otherwise only the latest version of every key is kept and older ones
+	are collected from blocks that key index points to.
+
+	Example:
+
+	blockchain
+
+	0	block0(height: 0, time: 10): tx{key: hello}, tx{key: apple}
+	28	block1(height: 1, time: 20): tx{key: hello}
+
+	Index
+
+		apple - [{offset: 0, height: 0, time: 10, position: 1}]
+		hello - [{offset: 0, height: 0, time: 10, position: 0},
+		         {offset: 28, height: 1, time: 20, position: 0}]
+*/
+
+// Location of key version, block and position of transaction in the block
+type StateLocation struct {
+	BlockLocation
+	// Timestamp of the block
+	Timestamp int64
+	Position  int
+	// Version that value of this version is built from, appends refer to
+	// the last put or delete before them, other versions refer to itself.
+	Base int
+}
+
+// Point of chain history, height of the block or time when it was created
+type StatePoint struct {
+	ByTime    bool
+	Height    uint64
+	Timestamp int64
+}
+
+// Latest version of key that is kept when history is off. Value of the
+// latest version is read from blocks starting from the one of its base.
+type keyHead struct {
+	// Count of versions of key
+	Count int
+	Last  StateLocation
+	// Offset of block with version that the latest one is based on
+	BaseOffset int64
+}
+
+type StateIndex struct {
+	// Mutex protects maps from races
+	m sync.RWMutex
+	// All versions of keys are kept with history, latest ones otherwise
+	history  bool
+	versions map[string][]StateLocation
+	heads    map[string]keyHead
+}
+
+func NewStateIndex(file io.ReadSeeker) (*StateIndex, error) {
+	GetLogger().Info("Start building state index")
+
+	index := newStateIndex(true)
+	height, err := replay(file, index.Update)
+
+	if err != nil {
+		return nil, err
+	}
+
+	GetLogger().Debugf("StateIndex has been built from %d blocks", height)
+	return index, nil
+}
+
+func newStateIndex(history bool) *StateIndex {
+	return &StateIndex{
+		history:  history,
+		versions: make(map[string][]StateLocation),
+		heads:    make(map[string]keyHead),
+	}
+}
+
+// Update index with new block
+func (index *StateIndex) Update(offset int64, height uint64, block *Block) {
+	index.m.Lock()
+	defer index.m.Unlock()
+
+	for i, tx := range block.Transactions {
+		if index.history {
+			index.versions[tx.Key] = appendVersion(index.versions[tx.Key], offset, height, block, i)
+		} else {
+			index.heads[tx.Key] = index.heads[tx.Key].next(offset, height, block, i)
+		}
+	}
+}
+
+// Returns head of key with version created by transaction on position of
+// block
+func (head keyHead) next(offset int64, height uint64, block *Block, position int) keyHead {
+	base := head.Count
+
+	if block.Transactions[position].Type() == OP_APPEND && head.Count != 0 {
+		base = head.Last.Base
+	} else {
+		head.BaseOffset = offset
+	}
+
+	head.Count++
+	head.Last = StateLocation{
+		BlockLocation: BlockLocation{
+			Offset: offset,
+			Height: height,
+		},
+		Timestamp: block.Timestamp,
+		Position:  position,
+		Base:      base,
+	}
+
+	return head
+}
+
+// Tells whether the latest version is the one of key at point
+func (head keyHead) isLatestAt(point StatePoint) bool {
+	if point.ByTime {
+		return head.Last.Timestamp <= point.Timestamp
+	}
+
+	return head.Last.Height <= point.Height
+}
+
+// Appends version of key created by transaction on position of block
+func appendVersion(versions []StateLocation, offset int64, height uint64, block *Block, position int) []StateLocation {
+	base := len(versions)
+
+	if block.Transactions[position].Type() == OP_APPEND && len(versions) != 0 {
+		base = versions[len(versions)-1].Base
+	}
+
+	return append(versions, StateLocation{
+		BlockLocation: BlockLocation{
+			Offset: offset,
+			Height: height,
+		},
+		Timestamp: block.Timestamp,
+		Position:  position,
+		Base:      base,
+	})
+}
+
+// Returns versions of key in chain order
+func (index *StateIndex) Versions(key string) []StateLocation {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	versions := index.versions[key]
+
+	// Versions are only appended, copy protects from concurrent append
+	return append([]StateLocation{}, versions...)
+}
+
+// Returns the latest version of key, it is kept only when history is off
+func (index *StateIndex) Head(key string) (keyHead, bool) {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	head, ok := index.heads[key]
+	return head, ok
+}
+
+// Returns index of the last version created at point or before it, -1 if
+// key had no versions at that point.
+func versionAt(versions []StateLocation, point StatePoint) int {
+	// Versions are sorted by height and time since blocks are appended
+	return sort.Search(len(versions), func(i int) bool {
+		if point.ByTime {
+			return versions[i].Timestamp > point.Timestamp
+		}
+
+		return versions[i].Height > point.Height
+	}) - 1
+}
+
+// Collects versions of key by reading all blocks of file
+func keyVersions(key string, f io.ReadSeeker) ([]StateLocation, error) {
+	var versions []StateLocation
+
+	_, err := replay(f, func(offset int64, height uint64, block *Block) {
+		versions = appendBlockVersions(versions, key, offset, height, block)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Collects versions of key by reading blocks that key index points to
+func indexVersions(key string, index Index, hashIndex *HashIndex, f io.ReadSeeker) ([]StateLocation, error) {
+	offsets, err := index.Offsets(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return blockVersions(key, offsets, hashIndex, f)
+}
+
+// Collects versions of key from the block of base of its latest version up
+// to the latest version, returns them with count of versions before them.
+// Bases of versions are counted from the first version of key.
+func headVersions(key string, head keyHead, index Index, hashIndex *HashIndex, f io.ReadSeeker) ([]StateLocation, int, error) {
+	offsets, err := index.Offsets(key)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Offsets are sorted since blocks are appended to the end of file
+	i := sort.Search(len(offsets), func(i int) bool {
+		return offsets[i] >= head.BaseOffset
+	})
+
+	versions, err := blockVersions(key, offsets[i:], hashIndex, f)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Key index can point to blocks flushed after head has been read
+	for last, version := range versions {
+		if version.Offset != head.Last.Offset || version.Position != head.Last.Position {
+			continue
+		}
+
+		versions = versions[:last+1]
+		skip := head.Count - len(versions)
+
+		for i := range versions {
+			versions[i].Base += skip
+		}
+
+		return versions, skip, nil
+	}
+
+	return nil, 0, RecordMismatchErr
+}
+
+// Collects versions of key from blocks on offsets, height of block is taken
+// from hash index.
+func blockVersions(key string, offsets []int64, hashIndex *HashIndex, f io.ReadSeeker) ([]StateLocation, error) {
+	var versions []StateLocation
+
+	for _, offset := range offsets {
+		if _, err := f.Seek(offset, 0); err != nil {
+			return nil, err
+		}
+
+		block, _, err := readBlock(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Only bloom filter index points to blocks without key
+		if !block.hasKey(key) {
+			bloomFalsePositives.Inc()
+			continue
+		}
+
+		location, ok := hashIndex.Block(block.BlockHash)
+
+		if !ok {
+			return nil, RecordMismatchErr
+		}
+
+		versions = appendBlockVersions(versions, key, offset, location.Height, block)
+	}
+
+	return versions, nil
+}
+
+// Appends versions of key created by transactions of block
+func appendBlockVersions(versions []StateLocation, key string, offset int64, height uint64, block *Block) []StateLocation {
+	for i := range block.Transactions {
+		if block.Transactions[i].Key == key {
+			versions = appendVersion(versions, offset, height, block, i)
+		}
+	}
+
+	return versions
+}
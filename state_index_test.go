@@ -0,0 +1,50 @@
+package minichain
+
+import (
+	"testing"
+)
+
+func TestVersionAt(t *testing.T) {
+	versions := []StateLocation{
+		{BlockLocation: BlockLocation{Height: 1}, Timestamp: 100},
+		{BlockLocation: BlockLocation{Height: 1}, Timestamp: 100},
+		{BlockLocation: BlockLocation{Height: 4}, Timestamp: 200},
+	}
+
+	testData := []struct {
+		Point    StatePoint
+		Expected int
+	}{
+		{StatePoint{Height: 0}, -1},
+		{StatePoint{Height: 1}, 1},
+		{StatePoint{Height: 3}, 1},
+		{StatePoint{Height: 10}, 2},
+		{StatePoint{ByTime: true, Timestamp: 99}, -1},
+		{StatePoint{ByTime: true, Timestamp: 150}, 1},
+		{StatePoint{ByTime: true, Timestamp: 200}, 2},
+	}
+
+	for _, test := range testData {
+		if actual := versionAt(versions, test.Point); actual != test.Expected {
+			t.Errorf("Expected version %d at %v actual %d", test.Expected, test.Point, actual)
+		}
+	}
+}
+
+func TestParseStatePoint(t *testing.T) {
+	point, err := parseStatePoint("42")
+
+	if err != nil || point.ByTime || point.Height != 42 {
+		t.Errorf("Wrong height point %v %v", point, err)
+	}
+
+	point, err = parseStatePoint("2018-02-07T18:00:00Z")
+
+	if err != nil || !point.ByTime || point.Timestamp != 1518026400 {
+		t.Errorf("Wrong time point %v %v", point, err)
+	}
+
+	if _, err = parseStatePoint("yesterday"); err == nil {
+		t.Errorf("Error expected for wrong point")
+	}
+}
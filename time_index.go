@@ -0,0 +1,83 @@
+package minichain
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// Index keeps timestamp of every TIME_INDEX_STEP block
+	TIME_INDEX_STEP = 16
+	// Seconds that transaction timestamp may differ from time of its block,
+	// transaction waits for flush much less than skew
+	TIME_SCAN_MARGIN = 2 * MAX_TIMESTAMP_SKEW
+)
+
+/*
+	Sparse time index keeps timestamp and offset of every n-th block. Blocks
+	are appended in time order, so binary search over entries gives offset
+	close to the first block of time range without reading the whole file.
+
+	Example with step 2:
+
+	blockchain
+
+	0	block0: timestamp 100
+	28	block1: timestamp 160
+	76	block2: timestamp 220
+	98	block3: timestamp 280
+
+	Index
+
+		{timestamp: 100, offset: 0}
+		{timestamp: 220, offset: 76}
+*/
+
+type TimeEntry struct {
+	Timestamp int64
+	Offset    int64
+}
+
+type TimeIndex struct {
+	// Mutex protects entries from races
+	m       sync.RWMutex
+	step    uint64
+	entries []TimeEntry
+}
+
+func NewTimeIndex(step uint64) *TimeIndex {
+	return &TimeIndex{
+		step:    step,
+		entries: make([]TimeEntry, 0),
+	}
+}
+
+// Update index with new block
+func (index *TimeIndex) Update(offset int64, height uint64, block *Block) {
+	if height%index.step != 0 {
+		return
+	}
+
+	index.m.Lock()
+	index.entries = append(index.entries, TimeEntry{block.Timestamp, offset})
+	index.m.Unlock()
+}
+
+// Returns offset of block to start reading from to find all blocks with
+// timestamp greater or equal to from.
+func (index *TimeIndex) Locate(from int64) int64 {
+	index.m.RLock()
+	defer index.m.RUnlock()
+
+	// First entry with timestamp that is not less than from, blocks with
+	// required timestamps may be located before it
+	i := sort.Search(len(index.entries), func(i int) bool {
+		return index.entries[i].Timestamp >= from
+	})
+
+	if i == 0 {
+		return 0
+	}
+
+	return index.entries[i-1].Offset
+}
@@ -0,0 +1,102 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+// Builds chain of blocks created every 100 seconds, each block has two
+// transactions created 10 and 5 seconds before the block
+func buildTimeChain(t *testing.T, blockCount int) ([]int64, []byte) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	prevHash := genesis[:]
+	offsets := make([]int64, 0, blockCount)
+	data := make([]byte, 0)
+
+	for i := 1; i <= blockCount; i++ {
+		timestamp := int64(100 * i)
+		transactions := []Transaction{
+			{Key: fmt.Sprintf("key%d", i), Value: []byte("value"), Timestamp: timestamp - 10},
+			{Key: "key", Value: []byte("value"), Timestamp: timestamp - 5},
+		}
+
+		block := NewBlock(uint64(i-1), prevHash, transactions)
+		block.Timestamp = timestamp
+		block.BlockHash = block.Hash()
+
+		offsets = append(offsets, int64(len(data)))
+		data = append(data, encodeRecord(t, block)...)
+		prevHash = block.BlockHash
+	}
+
+	return offsets, data
+}
+
+func TestTimeIndexLocate(t *testing.T) {
+	offsets, data := buildTimeChain(t, 10)
+	index := NewTimeIndex(3)
+
+	if _, err := replay(bytes.NewReader(data), index.Update); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		From           int64
+		ExpectedOffset int64
+	}{
+		{0, 0},
+		{100, 0},
+		{450, offsets[3]},
+		{700, offsets[3]},
+		{701, offsets[6]},
+		{2000, offsets[9]},
+	}
+
+	for _, test := range testData {
+		if offset := index.Locate(test.From); offset != test.ExpectedOffset {
+			t.Errorf("Expected offset %d for %d actual %d", test.ExpectedOffset, test.From, offset)
+		}
+	}
+}
+
+func TestTimeScan(t *testing.T) {
+	offsets, data := buildTimeChain(t, 10)
+	reader := bytes.NewReader(data)
+
+	testData := []struct {
+		From        int64
+		To          int64
+		Key         string
+		Start       string
+		End         string
+		Limit       int
+		Offset      int64
+		ExpectedTxs int
+	}{
+		{From: 290, To: 595, Limit: 100, Offset: 0, ExpectedTxs: 8},
+		{From: 290, To: 595, Limit: 100, Offset: offsets[2], ExpectedTxs: 8},
+		{From: 290, To: 595, Key: "key", Limit: 100, Offset: offsets[1], ExpectedTxs: 4},
+		{From: 0, To: 2000, Limit: 4, Offset: 0, ExpectedTxs: 4},
+		{From: 1500, To: 2000, Limit: 100, Offset: 0, ExpectedTxs: 0},
+		{From: 0, To: 2000, Start: "key1", End: "key2", Limit: 100, Offset: 0, ExpectedTxs: 2},
+	}
+
+	for _, test := range testData {
+		if _, err := reader.Seek(test.Offset, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		txs, _, err := timeScan(test.From, test.To, test.Key, test.Start, test.End, Cursor{}, test.Limit, reader)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(txs) != test.ExpectedTxs {
+			t.Errorf("Expected %d transactions in [%d, %d] actual %d",
+				test.ExpectedTxs, test.From, test.To, len(txs))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package minichain
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+var ClientCAErr = errors.New("client certificates are required, but client CA is not set")
+
+// TLSReloader keeps TLS config of server built from certificate, key and
+// client CA files of [Http] section. Files are read again on Reload, so
+// certificates are rotated without restart, connections that are already
+// established keep their certificates.
+type TLSReloader struct {
+	// Mutex protects config that is swapped on reload
+	m      sync.RWMutex
+	config HttpConfig
+	tls    *tls.Config
+}
+
+func NewTLSReloader(config HttpConfig) (*TLSReloader, error) {
+	reloader := &TLSReloader{
+		config: config,
+	}
+
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Reads certificate files, current config is kept if any of them is broken
+func (reloader *TLSReloader) Reload() error {
+	tlsConfig, err := loadTLSConfig(reloader.config)
+
+	if err != nil {
+		return err
+	}
+
+	reloader.m.Lock()
+	reloader.tls = tlsConfig
+	reloader.m.Unlock()
+
+	GetLogger().Infof("TLS certificate has been loaded from %s", reloader.config.CertFile)
+	return nil
+}
+
+// Returns TLS config for listener, every handshake uses the config loaded
+// the last time
+func (reloader *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			reloader.m.RLock()
+			defer reloader.m.RUnlock()
+
+			return reloader.tls, nil
+		},
+	}
+}
+
+func loadTLSConfig(config HttpConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(config.ClientCAFile) == 0 {
+		if config.RequireClientCert {
+			return nil, ClientCAErr
+		}
+
+		return tlsConfig, nil
+	}
+
+	data, err := ioutil.ReadFile(config.ClientCAFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", config.ClientCAFile)
+	}
+
+	// Client certificate is verified if it is sent, mTLS requires it
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	if config.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
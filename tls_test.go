@@ -0,0 +1,186 @@
+package minichain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Creates certificate signed by parent, self-signed if parent is nil, and
+// writes it with its key to dir
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "server", ca, caKey)
+	writeTestCert(t, dir, "client", ca, caKey)
+
+	config := HttpConfig{
+		CertFile:          filepath.Join(dir, "server.crt"),
+		KeyFile:           filepath.Join(dir, "server.key"),
+		ClientCAFile:      filepath.Join(dir, "ca.crt"),
+		RequireClientCert: true,
+	}
+
+	reloader, err := NewTLSReloader(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	go server.Serve(tls.NewListener(l, reloader.TLSConfig()))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(certificates []tls.Certificate) (*x509.Certificate, error) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      roots,
+					Certificates: certificates,
+				},
+				DisableKeepAlives: true,
+			},
+		}
+
+		resp, err := client.Get("https://" + l.Addr().String())
+
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		return resp.TLS.PeerCertificates[0], nil
+	}
+
+	first, err := get([]tls.Certificate{clientCert})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := get(nil); err == nil {
+		t.Errorf("Client without certificate has been accepted")
+	}
+
+	// Certificate is rotated, broken files don't replace loaded one
+	writeTestCert(t, dir, "server", ca, caKey)
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := get([]tls.Certificate{clientCert})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.SerialNumber.Cmp(second.SerialNumber) == 0 {
+		t.Errorf("Server certificate has not been reloaded")
+	}
+
+	if err := ioutil.WriteFile(config.KeyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reloader.Reload(); err == nil {
+		t.Errorf("Broken key has been loaded")
+	}
+
+	if _, err := get([]tls.Certificate{clientCert}); err != nil {
+		t.Errorf("Previous certificate is not served after failed reload %v", err)
+	}
+
+	if _, err := NewTLSReloader(HttpConfig{CertFile: config.CertFile, KeyFile: filepath.Join(dir, "client.key")}); err == nil {
+		t.Errorf("Certificate with wrong key has been loaded")
+	}
+}
@@ -3,29 +3,169 @@ package minichain
 import (
 	"bytes"
 	"crypto/sha256"
-	"strconv"
+	"encoding/binary"
 	"time"
 )
 
+const (
+	// Transaction is in the batch that is not flushed yet
+	TX_PENDING = "pending"
+	// Transaction is written to disk
+	TX_COMMITTED = "committed"
+	TX_UNKNOWN   = "unknown"
+
+	// Transaction sets value of key, transactions without operation are puts
+	OP_PUT = "put"
+	// Tombstone, key is absent after this transaction
+	OP_DELETE = "delete"
+	// Value is appended to the current value of key
+	OP_APPEND = "append"
+	// Value is set only if key matches expected value or transaction id or
+	// if key is absent
+	OP_CAS = "cas"
+
+	// Seconds that timestamp of signed transaction may differ from server
+	// time, timestamps of other transactions are set by server
+	MAX_TIMESTAMP_SKEW = 300
+)
+
 type Transaction struct {
 	Id        []byte `json:"id"`
+	Op        string `json:"op,omitempty"`
 	Key       string `json:"key"`
-	Value     string `json:"value"`
+	Value     []byte `json:"value"`
 	Timestamp int64  `json:"timestamp"`
+	// Condition of compare-and-set transaction
+	Expected     []byte `json:"expected,omitempty"`
+	ExpectedId   []byte `json:"expected-id,omitempty"`
+	ExpectAbsent bool   `json:"expect-absent,omitempty"`
+	// Ed25519 public key of signer and signature of Header
+	PublicKey []byte `json:"public-key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+func NewTransaction(key string, value []byte) *Transaction {
+	return NewTypedTransaction(OP_PUT, key, value)
 }
 
-func NewTransaction(key, value string) *Transaction {
+// Creates tombstone that deletes key
+func NewDeleteTransaction(key string) *Transaction {
+	return NewTypedTransaction(OP_DELETE, key, nil)
+}
+
+// Creates transaction with operation, empty operation is put
+func NewTypedTransaction(op, key string, value []byte) *Transaction {
+	if len(op) == 0 {
+		op = OP_PUT
+	}
+
 	tx := &Transaction{
+		Op:        op,
 		Key:       key,
 		Value:     value,
 		Timestamp: time.Now().Unix(),
 	}
 
-	timestampBytes := []byte(strconv.FormatInt(tx.Timestamp, 10))
+	tx.Id = tx.Hash()
+
+	return tx
+}
+
+// Creates compare-and-set transaction, key must have expected value if it is
+// set or expected transaction id is not set, so empty value can be expected
+func NewCompareAndSetTransaction(key string, value, expected, expectedId []byte) *Transaction {
+	tx := &Transaction{
+		Op:         OP_CAS,
+		Key:        key,
+		Value:      value,
+		Timestamp:  time.Now().Unix(),
+		Expected:   expected,
+		ExpectedId: expectedId,
+	}
+
+	tx.Id = tx.Hash()
+
+	return tx
+}
+
+// Creates compare-and-set transaction that sets value only if key is absent
+func NewCompareAndSetAbsentTransaction(key string, value []byte) *Transaction {
+	tx := &Transaction{
+		Op:           OP_CAS,
+		Key:          key,
+		Value:        value,
+		Timestamp:    time.Now().Unix(),
+		ExpectAbsent: true,
+	}
 
-	header := bytes.Join([][]byte{[]byte(key), []byte(value), timestampBytes}, []byte{})
-	hash := sha256.Sum256(header)
-	tx.Id = hash[:]
+	tx.Id = tx.Hash()
 
 	return tx
 }
+
+// Hash returns id of transaction, it covers header and signature of signed
+// transaction.
+func (tx *Transaction) Hash() []byte {
+	data := tx.Header()
+
+	if len(tx.PublicKey) != 0 || len(tx.Signature) != 0 {
+		buf := bytes.NewBuffer(data)
+		writeBytes(buf, tx.PublicKey)
+		writeBytes(buf, tx.Signature)
+		data = buf.Bytes()
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// Returns operation of transaction, transactions written before operations
+// were introduced are puts
+func (tx *Transaction) Type() string {
+	if len(tx.Op) == 0 {
+		return OP_PUT
+	}
+
+	return tx.Op
+}
+
+// Returns whether transaction is tombstone of its key
+func (tx *Transaction) IsDelete() bool {
+	return tx.Type() == OP_DELETE
+}
+
+// Header returns canonical encoding of transaction that is used for
+// transaction id, key and value are length prefixed, so "ab"+"c" and
+// "a"+"bc" give different ids. Operation is prepended only when it is set,
+// so ids of puts stay the same as before operations were introduced.
+func (tx *Transaction) Header() []byte {
+	buf := &bytes.Buffer{}
+
+	if len(tx.Op) != 0 {
+		writeBytes(buf, []byte(tx.Op))
+	}
+
+	writeBytes(buf, []byte(tx.Key))
+	writeBytes(buf, tx.Value)
+	binary.Write(buf, binary.LittleEndian, tx.Timestamp)
+
+	if tx.Op == OP_CAS {
+		writeBytes(buf, tx.Expected)
+		writeBytes(buf, tx.ExpectedId)
+
+		// Appended only when set, so ids of other transactions stay the same
+		if tx.ExpectAbsent {
+			buf.WriteByte(1)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Receipt returned to client after transaction has been accepted, id is hex
+// encoded to be used in /tx/{id} and /tx/{id}/status requests.
+type TransactionReceipt struct {
+	Id        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
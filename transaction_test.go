@@ -0,0 +1,28 @@
+package minichain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTransactionHeader(t *testing.T) {
+	tx1 := &Transaction{Key: "ab", Value: []byte("c"), Timestamp: 1}
+	tx2 := &Transaction{Key: "a", Value: []byte("bc"), Timestamp: 1}
+
+	if bytes.Equal(tx1.Header(), tx2.Header()) {
+		t.Errorf("Transactions with different keys have the same header")
+	}
+}
+
+func TestDeleteTransaction(t *testing.T) {
+	put := &Transaction{Key: "key", Timestamp: 1}
+	tombstone := &Transaction{Op: OP_DELETE, Key: "key", Timestamp: 1}
+
+	if bytes.Equal(put.Header(), tombstone.Header()) {
+		t.Errorf("Tombstone has the same header as put with empty value")
+	}
+
+	if tx := NewDeleteTransaction("key"); !tx.IsDelete() || len(tx.Value) != 0 {
+		t.Errorf("Wrong tombstone %v", tx)
+	}
+}
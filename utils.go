@@ -1,9 +1,9 @@
 package minichain
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"io"
 	"sync"
 )
@@ -12,66 +12,171 @@ var (
 	m sync.Mutex
 )
 
-func getLastBlockHash(f io.ReadSeeker) ([]byte, error) {
-	_, err := f.Seek(-DIGEST_SIZE, 2)
+// Writes length prefixed slice to buffer, so bytes of adjacent fields
+// can't be moved from one field to another without changing encoding.
+func writeBytes(buf *bytes.Buffer, data []byte) {
+	size := make([]byte, HEADER_SIZE)
+	binary.LittleEndian.PutUint32(size, uint32(len(data)))
+	buf.Write(size)
+	buf.Write(data)
+}
 
-	if err != nil {
-		return nil, err
+func fullScan(key string, cursor Cursor, limit int, f io.ReadSeeker) ([]Transaction, *Cursor, error) {
+	var (
+		next         *Cursor
+		transactions = make([]Transaction, 0)
+	)
+
+	if _, err := f.Seek(cursor.Offset, 0); err != nil {
+		return nil, nil, err
 	}
 
-	prevBlockHash := make([]byte, DIGEST_SIZE)
-	n, err := f.Read(prevBlockHash)
+	for next == nil {
+		block, offset, err := readBlock(f)
 
-	if err != nil {
-		return nil, err
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		GetLogger().Debugf("Read block id %x on offset %d", block.BlockHash, offset)
+		transactions, next = pageBlock(block, offset, cursor, limit, transactions, func(tx *Transaction) bool {
+			return tx.Key == key
+		})
 	}
 
-	if n != DIGEST_SIZE {
-		return nil, errors.New("not enough bytes to read")
+	if len(transactions) == 0 {
+		return nil, nil, KeyNotFoundErr
 	}
 
-	return prevBlockHash, nil
+	return transactions, next, nil
 }
 
-func fullScan(key string, f io.ReadSeeker) ([]Transaction, error) {
+// Reads blocks from reader till the end and passes each of them with its
+// offset and height to update function, returns count of blocks read.
+func replay(f io.ReadSeeker, update func(int64, uint64, *Block)) (uint64, error) {
+	var height uint64
+
+	for {
+		block, offset, err := readBlock(f)
+
+		if err == io.EOF {
+			return height, nil
+		}
+
+		if err != nil {
+			return height, err
+		}
+
+		update(offset, height, block)
+		height++
+	}
+}
+
+// Reads blocks starting from current position of reader and returns at most
+// limit transactions with timestamp in range [from, to], key if it is set
+// and key in range [start, end) together with cursor of the next page.
+// Timestamp of signed transaction differs from time of its block by skew and
+// time it waited for flush, so blocks are compared to range with margin.
+// Reading stops at block that is newer than range.
+func timeScan(from, to int64, key, start, end string, cursor Cursor, limit int,
+	f io.ReadSeeker) ([]Transaction, *Cursor, error) {
 	var (
-		err          error
-		blockCount   int64
-		offset       int64
-		block        *Block
+		next         *Cursor
 		transactions = make([]Transaction, 0)
 	)
 
-	for {
-		if block, offset, err = readBlock(f); err != nil {
-			if err != io.EOF {
-				return nil, err
-			} else {
-				break
-			}
-		} else {
-			GetLogger().Debugf("Read block id %s on offset %d",
-				string(block.BlockHash), offset)
-			for _, tx := range block.Transactions {
-				if tx.Key == key {
-					transactions = append(transactions, tx)
-				}
-			}
-			blockCount++
+	for next == nil {
+		block, offset, err := readBlock(f)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, err
 		}
+
+		if block.Timestamp+TIME_SCAN_MARGIN < from {
+			continue
+		}
+
+		if block.Timestamp-TIME_SCAN_MARGIN > to {
+			break
+		}
+
+		transactions, next = pageBlock(block, offset, cursor, limit, transactions, func(tx *Transaction) bool {
+			return tx.Timestamp >= from && tx.Timestamp <= to && (len(key) == 0 || tx.Key == key) &&
+				keyInRange(tx.Key, start, end)
+		})
 	}
 
-	if len(transactions) == 0 {
-		return nil, KeyNotFoundErr
+	return transactions, next, nil
+}
+
+// Scans whole blockchain for transactions with keys in range [start, end),
+// only transactions of one page are kept in memory.
+func rangeScan(start, end string, cursor Cursor, limit int, f io.ReadSeeker) ([]Transaction, string, *Cursor, error) {
+	page := newRangePage(start, end, cursor, limit)
+
+	for {
+		block, offset, err := readBlock(f)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		page.add(block, offset)
 	}
 
-	return transactions, nil
+	transactions, next, nextCursor := page.result()
 
+	return transactions, next, nextCursor, nil
+}
+
+// Scans blockchain for the first block that matches, height of the block
+// is counted from the beginning of reader.
+func findBlock(f io.ReadSeeker, match func(*Block) bool) (*Block, BlockLocation, error) {
+	var height uint64
+
+	for {
+		block, offset, err := readBlock(f)
+
+		if err == io.EOF {
+			return nil, BlockLocation{}, BlockNotFoundErr
+		}
+
+		if err != nil {
+			return nil, BlockLocation{}, err
+		}
+
+		if match(block) {
+			return block, BlockLocation{offset, height}, nil
+		}
+
+		height++
+	}
 }
 
 // Reads block from blockchain writer, assumes that writer pointer of fd is set on
 // the beginning of next block
 func readBlock(reader io.ReadSeeker) (*Block, int64, error) {
+	block, _, offset, err := readRecord(reader)
+
+	return block, offset, err
+}
+
+// Reads whole record from blockchain file: header, block and digest appended
+// to the end of record. Record that is cut in the middle is reported with
+// NotEnoughDataErr, io.EOF is returned only when reader is at the end of file.
+func readRecord(reader io.ReadSeeker) (*Block, []byte, int64, error) {
 	// Protect function with lock since it modifies reader state
 	m.Lock()
 	defer m.Unlock()
@@ -79,34 +184,47 @@ func readBlock(reader io.ReadSeeker) (*Block, int64, error) {
 	offset, err := reader.Seek(0, 1)
 
 	if err != nil {
-		return nil, offset, err
+		return nil, nil, offset, err
 	}
 
 	headerData := make([]byte, HEADER_SIZE)
-	n, err := reader.Read(headerData)
+	_, err = io.ReadFull(reader, headerData)
 
-	if err != nil {
-		return nil, offset, err
+	if err == io.ErrUnexpectedEOF {
+		return nil, nil, offset, NotEnoughDataErr
 	}
 
-	if n != HEADER_SIZE {
-		return nil, offset, NotEnoughDataErr
+	if err != nil {
+		return nil, nil, offset, err
 	}
 
 	blockSize := binary.LittleEndian.Uint32(headerData)
-	blockBuffer := make([]byte, blockSize)
-	n, err = reader.Read(blockBuffer)
+
+	// Size comes from disk, broken header must not make buffer larger than
+	// the rest of file
+	end, err := reader.Seek(0, 2)
 
 	if err != nil {
-		return nil, offset, err
+		return nil, nil, offset, err
+	}
+
+	if _, err := reader.Seek(offset+HEADER_SIZE, 0); err != nil {
+		return nil, nil, offset, err
+	}
+
+	if int64(blockSize)+DIGEST_SIZE > end-offset-HEADER_SIZE {
+		return nil, nil, offset, NotEnoughDataErr
 	}
 
-	if uint32(n) != blockSize {
-		return nil, offset, NotEnoughDataErr
+	blockBuffer := make([]byte, blockSize)
+	_, err = io.ReadFull(reader, blockBuffer)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, nil, offset, NotEnoughDataErr
 	}
 
 	if err != nil {
-		return nil, offset, err
+		return nil, nil, offset, err
 	}
 
 	var block = &Block{}
@@ -114,15 +232,48 @@ func readBlock(reader io.ReadSeeker) (*Block, int64, error) {
 	err = json.Unmarshal(blockBuffer, block)
 
 	if err != nil {
-		return nil, offset, err
+		return nil, nil, offset, err
 	}
 
-	// Set reader to begin of next block or EOF
-	_, err = reader.Seek(DIGEST_SIZE, 1)
+	// Read digest and set reader to begin of next block or EOF
+	digest := make([]byte, DIGEST_SIZE)
+	_, err = io.ReadFull(reader, digest)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, nil, offset, NotEnoughDataErr
+	}
 
 	if err != nil {
-		return nil, offset, err
+		return nil, nil, offset, err
+	}
+
+	return block, digest, offset, nil
+}
+
+// Checks that record of block with hash starts on offset in data file and
+// returns offset of the next record without decoding block.
+func checkRecord(file io.ReadSeeker, offset int64, hash []byte) (int64, error) {
+	if _, err := file.Seek(offset, 0); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, HEADER_SIZE)
+
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, RecordMismatchErr
+	}
+
+	blockSize := int64(binary.LittleEndian.Uint32(header))
+
+	if _, err := file.Seek(blockSize, 1); err != nil {
+		return 0, err
+	}
+
+	digest := make([]byte, DIGEST_SIZE)
+
+	if _, err := io.ReadFull(file, digest); err != nil || !bytes.Equal(digest, hash) {
+		return 0, RecordMismatchErr
 	}
 
-	return block, offset, nil
+	return offset + HEADER_SIZE + blockSize + DIGEST_SIZE, nil
 }
@@ -5,24 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
+	"math"
 	"testing"
 )
 
-func TestGetLastBlockHash(t *testing.T) {
-	backedSlice := sha256.Sum256([]byte(GENESIS_BLOCK))
-	buffer := bytes.NewReader(backedSlice[:])
-
-	hash, err := getLastBlockHash(buffer)
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	if !bytes.Equal(hash, backedSlice[:]) {
-		t.Errorf("Hash from file %v is not equal to actual hash %v", hash, backedSlice)
-	}
-}
-
 func TestFullScan(t *testing.T) {
 	key := "key"
 	expectedTxs := 2
@@ -42,7 +28,7 @@ func TestFullScan(t *testing.T) {
 					Id:        []byte("dummy-tx"),
 					Timestamp: 1,
 					Key:       "key1",
-					Value:     "value1",
+					Value:     []byte("value1"),
 				},
 			},
 		},
@@ -55,7 +41,7 @@ func TestFullScan(t *testing.T) {
 					Id:        []byte("dummy-tx"),
 					Timestamp: 2,
 					Key:       key,
-					Value:     "value2",
+					Value:     []byte("value2"),
 				},
 			},
 		},
@@ -68,13 +54,13 @@ func TestFullScan(t *testing.T) {
 					Id:        []byte("dummy-tx"),
 					Timestamp: 3,
 					Key:       "key3",
-					Value:     "value3",
+					Value:     []byte("value3"),
 				},
 				{
 					Id:        []byte("dummy-tx"),
 					Timestamp: 4,
 					Key:       key,
-					Value:     "value4",
+					Value:     []byte("value4"),
 				},
 			},
 		},
@@ -98,7 +84,7 @@ func TestFullScan(t *testing.T) {
 	}
 
 	file := bytes.NewReader(backedArray)
-	txs, err := fullScan(key, file)
+	txs, _, err := fullScan(key, Cursor{}, DEFAULT_SEARCH_LIMIT, file)
 
 	if err != nil {
 		t.Error(err)
@@ -123,13 +109,13 @@ func TestReadBlock(t *testing.T) {
 				Id:        []byte("dummy-tx-2"),
 				Timestamp: 1,
 				Key:       "key1",
-				Value:     "value1",
+				Value:     []byte("value1"),
 			},
 			{
 				Id:        []byte("dummy-tx-2"),
 				Timestamp: 1,
 				Key:       "key2",
-				Value:     "value2",
+				Value:     []byte("value2"),
 			},
 		},
 	}
@@ -163,3 +149,13 @@ func TestReadBlock(t *testing.T) {
 			len(block.Transactions), len(b.Transactions))
 	}
 }
+
+func TestReadRecordBrokenHeader(t *testing.T) {
+	header := make([]byte, HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header, math.MaxUint32)
+	data := append(header, []byte("short block")...)
+
+	if _, _, _, err := readRecord(bytes.NewReader(data)); err != NotEnoughDataErr {
+		t.Errorf("Expected error %v actual %v", NotEnoughDataErr, err)
+	}
+}
@@ -0,0 +1,122 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	DigestMismatchErr = errors.New("record digest does not match block hash")
+	HashMismatchErr   = errors.New("block hash does not match block contents")
+	BrokenLinkErr     = errors.New("prev block hash does not match previous block")
+	MerkleRootErr     = errors.New("merkle root does not match block transactions")
+	HeightMismatchErr = errors.New("block height does not match its position in chain")
+	TxIdMismatchErr   = errors.New("transaction id does not match transaction contents")
+)
+
+// ChainError describes the first problem found in blockchain file and
+// the offset of the record where it has been found.
+type ChainError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Err.Error())
+}
+
+// VerifyChain walks through blockchain file from the beginning and checks
+// that each record is complete, digest appended to the record is equal to
+// block hash, block hash matches block contents and prev block hash refers
+// to the previous record (genesis for the first one). Signatures of signed
+// transactions are re-checked and, if policy is not nil, their signers as well.
+// Returns count of valid blocks and *ChainError for the first broken record.
+func VerifyChain(reader io.ReadSeeker, policy *SignerPolicy) (uint64, error) {
+	var (
+		blockCount uint64
+		genesis    = sha256.Sum256([]byte(GENESIS_BLOCK))
+		prevHash   = genesis[:]
+	)
+
+	if _, err := reader.Seek(0, 0); err != nil {
+		return 0, err
+	}
+
+	for {
+		block, digest, offset, err := readRecord(reader)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return blockCount, &ChainError{offset, err}
+		}
+
+		if err := verifyBlock(block, digest, prevHash, blockCount, policy); err != nil {
+			return blockCount, &ChainError{offset, err}
+		}
+
+		GetLogger().Debugf("Block on offset %d is valid", offset)
+		prevHash = block.BlockHash
+		blockCount++
+	}
+
+	GetLogger().Infof("Blockchain has been verified, %d blocks are valid", blockCount)
+	return blockCount, nil
+}
+
+func verifyBlock(block *Block, digest, prevHash []byte, height uint64, policy *SignerPolicy) error {
+	if !bytes.Equal(digest, block.BlockHash) {
+		return DigestMismatchErr
+	}
+
+	// Legacy blocks have neither merkle root nor height
+	if block.Version != BLOCK_VERSION_LEGACY {
+		if !bytes.Equal(merkleRoot(block.Transactions), block.MerkleRoot) {
+			return MerkleRootErr
+		}
+
+		if block.Height != height {
+			return HeightMismatchErr
+		}
+	}
+
+	if !bytes.Equal(block.Hash(), block.BlockHash) {
+		return HashMismatchErr
+	}
+
+	if !bytes.Equal(block.PrevBlockHash, prevHash) {
+		return BrokenLinkErr
+	}
+
+	for i := range block.Transactions {
+		if err := verifyTransaction(&block.Transactions[i], block.Version, policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks id, signature and signer of transaction. Merkle root covers only
+// ids, so id must match contents of transaction. Ids of legacy blocks were
+// computed differently and can't be checked.
+func verifyTransaction(tx *Transaction, version uint32, policy *SignerPolicy) error {
+	if version != BLOCK_VERSION_LEGACY && !bytes.Equal(tx.Id, tx.Hash()) {
+		return TxIdMismatchErr
+	}
+
+	if err := tx.Verify(); err != nil {
+		return err
+	}
+
+	if policy != nil {
+		return policy.Allow(tx)
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package minichain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func encodeRecord(t *testing.T, block *Block) []byte {
+	blockBytes, err := json.Marshal(block)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header, uint32(len(blockBytes)))
+
+	return bytes.Join([][]byte{header, blockBytes, block.BlockHash}, []byte{})
+}
+
+func buildChain(t *testing.T, blockCount int) ([]*Block, []byte) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	prevHash := genesis[:]
+	blocks := make([]*Block, 0, blockCount)
+	data := make([]byte, 0)
+
+	for i := 0; i < blockCount; i++ {
+		block := NewBlock(uint64(i), prevHash, []Transaction{*NewTransaction(fmt.Sprintf("key%d", i), []byte("value"))})
+		blocks = append(blocks, block)
+		data = append(data, encodeRecord(t, block)...)
+		prevHash = block.BlockHash
+	}
+
+	return blocks, data
+}
+
+func TestVerifyChain(t *testing.T) {
+	_, data := buildChain(t, 3)
+
+	blockCount, err := VerifyChain(bytes.NewReader(data), nil)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if blockCount != 3 {
+		t.Errorf("Expected block count %d actual %d", 3, blockCount)
+	}
+}
+
+func TestVerifyChainBroken(t *testing.T) {
+	blocks, _ := buildChain(t, 3)
+	first := encodeRecord(t, blocks[0])
+
+	tamperedRoot := *blocks[1]
+	tamperedRoot.Transactions = []Transaction{*NewTransaction("key", []byte("other"))}
+
+	tamperedHash := tamperedRoot
+	tamperedHash.MerkleRoot = merkleRoot(tamperedHash.Transactions)
+
+	wrongHeight := *blocks[1]
+	wrongHeight.Height = 5
+	wrongHeight.BlockHash = wrongHeight.Hash()
+
+	otherHash := sha256.Sum256([]byte("other block"))
+	brokenLink := *blocks[1]
+	brokenLink.PrevBlockHash = otherHash[:]
+	brokenLink.BlockHash = brokenLink.Hash()
+
+	// Id, merkle root and block hash are kept, only contents are rewritten
+	tamperedTx := *blocks[1]
+	tamperedTx.Transactions = append([]Transaction{}, blocks[1].Transactions...)
+	tamperedTx.Transactions[0].Key = "other"
+	tamperedTx.Transactions[0].Value = []byte("other")
+
+	wrongDigest := encodeRecord(t, blocks[1])
+	wrongDigest[len(wrongDigest)-1] ^= 0xff
+
+	testData := []struct {
+		Data        []byte
+		ExpectedErr error
+	}{
+		{
+			Data:        append(first, encodeRecord(t, &tamperedRoot)...),
+			ExpectedErr: MerkleRootErr,
+		},
+		{
+			Data:        append(first, encodeRecord(t, &tamperedHash)...),
+			ExpectedErr: HashMismatchErr,
+		},
+		{
+			Data:        append(first, encodeRecord(t, &wrongHeight)...),
+			ExpectedErr: HeightMismatchErr,
+		},
+		{
+			Data:        append(first, encodeRecord(t, &brokenLink)...),
+			ExpectedErr: BrokenLinkErr,
+		},
+		{
+			Data:        append(first, encodeRecord(t, &tamperedTx)...),
+			ExpectedErr: TxIdMismatchErr,
+		},
+		{
+			Data:        append(first, wrongDigest...),
+			ExpectedErr: DigestMismatchErr,
+		},
+		{
+			Data:        append(first, encodeRecord(t, blocks[1])[:10]...),
+			ExpectedErr: NotEnoughDataErr,
+		},
+	}
+
+	for _, test := range testData {
+		blockCount, err := VerifyChain(bytes.NewReader(test.Data), nil)
+
+		chainErr, ok := err.(*ChainError)
+
+		if !ok {
+			t.Errorf("Expected chain error actual %v", err)
+			continue
+		}
+
+		if chainErr.Err != test.ExpectedErr {
+			t.Errorf("Expected error %v actual %v", test.ExpectedErr, chainErr.Err)
+		}
+
+		if chainErr.Offset != int64(len(first)) {
+			t.Errorf("Expected offset %d actual %d", len(first), chainErr.Offset)
+		}
+
+		if blockCount != 1 {
+			t.Errorf("Expected block count %d actual %d", 1, blockCount)
+		}
+	}
+}
+
+func TestVerifyChainLegacy(t *testing.T) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	block := &Block{
+		Timestamp:     1,
+		PrevBlockHash: genesis[:],
+		Transactions: []Transaction{
+			{
+				Id:        []byte("dummy-tx"),
+				Timestamp: 1,
+				Key:       "key",
+				Value:     []byte("value"),
+			},
+		},
+	}
+	block.BlockHash = block.Hash()
+
+	blockCount, err := VerifyChain(bytes.NewReader(encodeRecord(t, block)), nil)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if blockCount != 1 {
+		t.Errorf("Expected block count %d actual %d", 1, blockCount)
+	}
+}
+
+func TestVerifyChainSignature(t *testing.T) {
+	genesis := sha256.Sum256([]byte(GENESIS_BLOCK))
+	signed := NewTransaction("key", []byte("value"))
+	signed.Sign(testKey(1))
+
+	// Id is recomputed, so only signature check finds forged value
+	forged := *signed
+	forged.Value = []byte("other")
+	forged.Id = forged.Hash()
+
+	policy, err := NewSignerPolicy([]Namespace{{Prefix: "key"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []struct {
+		Transaction *Transaction
+		Policy      *SignerPolicy
+		ExpectedErr error
+	}{
+		{signed, nil, nil},
+		{&forged, nil, SignatureErr},
+		{signed, policy, SignerNotAllowedErr},
+	}
+
+	for i, test := range testData {
+		block := NewBlock(0, genesis[:], []Transaction{*test.Transaction})
+		_, err := VerifyChain(bytes.NewReader(encodeRecord(t, block)), test.Policy)
+
+		if test.ExpectedErr == nil {
+			if err != nil {
+				t.Errorf("test %d unexpected error %v", i, err)
+			}
+			continue
+		}
+
+		if chainErr, ok := err.(*ChainError); !ok || chainErr.Err != test.ExpectedErr {
+			t.Errorf("test %d expected error %v actual %v", i, test.ExpectedErr, err)
+		}
+	}
+}